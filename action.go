@@ -0,0 +1,600 @@
+package wlmanip
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/msq"
+)
+
+// IDLoot is the index of the action table containing loot bags.
+const IDLoot = 5
+
+// LootEntry represents a single loot bag.  Like a TransEntry, it contains a
+// copy of the loot; modifications are made with SetLoot.
+type LootEntry struct {
+	FromBlock defs.BlockZIP
+	FromLoc   int // Inexact (i.e., never a sub-location).
+	Loot      action.Loot
+	Selector  int
+
+	ExactLoc int
+}
+
+// MonsterEntry represents a single type of monster within an MSQ block.
+type MonsterEntry struct {
+	FromBlock defs.BlockZIP
+	FromLoc   int
+	Name      decode.MonsterName
+	Data      decode.MonsterDataElem
+	Index     int
+
+	ExactLoc int
+}
+
+// NPCEntry represents a single NPC within an MSQ block.
+type NPCEntry struct {
+	FromBlock defs.BlockZIP
+	FromLoc   int
+	NPC       decode.NPC
+	Index     int
+}
+
+// ActionEntry represents a single element of an action table that wllib does
+// not decode (i.e., any table other than loots and transitions).
+type ActionEntry struct {
+	FromBlock defs.BlockZIP
+	FromLoc   int
+	Table     int
+	Data      []byte
+	Selector  int
+}
+
+// forEachBlock calls the given function for every MSQ block in a decode
+// state, in order of game index and block index.
+func forEachBlock(state decode.DecodeState,
+	fn func(zip defs.BlockZIP, loc int, block *decode.Block) error) error {
+
+	for gameIdx, _ := range state.Blocks {
+		for blockIdx, _ := range state.Blocks[gameIdx] {
+			zip := defs.BlockZIP{
+				GameIdx:  gameIdx,
+				BlockIdx: blockIdx,
+			}
+			loc, err := defs.BlockZIPToLoc(zip)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(zip, loc, &state.Blocks[gameIdx][blockIdx]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// blockAt retrieves the MSQ block with the given ZIP from a decode state.
+func blockAt(state *decode.DecodeState, zip defs.BlockZIP) (*decode.Block, error) {
+	if zip.GameIdx < 0 || zip.GameIdx >= len(state.Blocks) ||
+		zip.BlockIdx < 0 || zip.BlockIdx >= len(state.Blocks[zip.GameIdx]) {
+
+		return nil, wlerr.Errorf("invalid block zip: %+v", zip)
+	}
+
+	return &state.Blocks[zip.GameIdx][zip.BlockIdx], nil
+}
+
+// genTable retrieves the undecoded action table with the given index.
+func genTable(tables *action.Tables, tableIdx int) (*gen.Table, error) {
+	switch tableIdx {
+	case 0:
+		return &tables.T0, nil
+	case 1:
+		return &tables.T1, nil
+	case 2:
+		return &tables.T2, nil
+	case 3:
+		return &tables.T3, nil
+	case 4:
+		return &tables.T4, nil
+	case 6:
+		return &tables.T6, nil
+	case 7:
+		return &tables.T7, nil
+	case 8:
+		return &tables.T8, nil
+	case 9:
+		return &tables.T9, nil
+	case 11:
+		return &tables.T11, nil
+	case 12:
+		return &tables.T12, nil
+	case 13:
+		return &tables.T13, nil
+	case 14:
+		return &tables.T14, nil
+	case 15:
+		return &tables.T15, nil
+	default:
+		return nil, wlerr.Errorf(
+			"invalid action table index: have=%d want=0-15 (not %d or %d)",
+			tableIdx, IDLoot, action.IDTransition)
+	}
+}
+
+// CollectLoots gathers every loot bag from among all MSQ blocks.  Each bag is
+// annotated with the exact location containing it.
+func CollectLoots(state decode.DecodeState) ([]*LootEntry, error) {
+	return collectLoots(state, NewLocationRegistry())
+}
+
+func collectLoots(state decode.DecodeState,
+	locs *LocationRegistry) ([]*LootEntry, error) {
+
+	var entries []*LootEntry
+
+	err := forEachBlock(state, func(zip defs.BlockZIP, loc int,
+		block *decode.Block) error {
+
+		for selector, loot := range block.ActionTables.Loots {
+			if loot != nil {
+				entries = append(entries, &LootEntry{
+					FromBlock: zip,
+					FromLoc:   loc,
+					Loot:      cloneLoot(*loot),
+					Selector:  selector,
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	assignLootExactLocs(state, locs, entries)
+
+	return entries, nil
+}
+
+// CollectMonsters gathers every monster definition from among all MSQ blocks.
+// Each monster is annotated with the exact location containing it.
+func CollectMonsters(state decode.DecodeState) ([]*MonsterEntry, error) {
+	return collectMonsters(state, NewLocationRegistry())
+}
+
+func collectMonsters(state decode.DecodeState,
+	locs *LocationRegistry) ([]*MonsterEntry, error) {
+
+	var entries []*MonsterEntry
+
+	err := forEachBlock(state, func(zip defs.BlockZIP, loc int,
+		block *decode.Block) error {
+
+		for i, m := range block.MonsterData.Monsters {
+			entry := &MonsterEntry{
+				FromBlock: zip,
+				FromLoc:   loc,
+				Data:      m,
+				Index:     i,
+			}
+			if i < len(block.MonsterNames.Names) {
+				entry.Name = block.MonsterNames.Names[i]
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	assignMonsterExactLocs(state, locs, entries)
+
+	return entries, nil
+}
+
+// CollectNPCs gathers every NPC from among all MSQ blocks.
+func CollectNPCs(state decode.DecodeState) ([]*NPCEntry, error) {
+	var entries []*NPCEntry
+
+	err := forEachBlock(state, func(zip defs.BlockZIP, loc int,
+		block *decode.Block) error {
+
+		for i, npc := range block.NPCTable.NPCs {
+			entries = append(entries, &NPCEntry{
+				FromBlock: zip,
+				FromLoc:   loc,
+				NPC: decode.NPC{
+					Data: append([]byte{}, npc.Data...),
+				},
+				Index: i,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// CollectActionTable gathers every element of the specified undecoded action
+// table from among all MSQ blocks.
+func CollectActionTable(state decode.DecodeState,
+	tableIdx int) ([]*ActionEntry, error) {
+
+	var entries []*ActionEntry
+
+	err := forEachBlock(state, func(zip defs.BlockZIP, loc int,
+		block *decode.Block) error {
+
+		t, err := genTable(&block.ActionTables, tableIdx)
+		if err != nil {
+			return err
+		}
+
+		for selector, elem := range t.Elems {
+			if len(elem) > 0 {
+				entries = append(entries, &ActionEntry{
+					FromBlock: zip,
+					FromLoc:   loc,
+					Table:     tableIdx,
+					Data:      append([]byte{}, elem...),
+					Selector:  selector,
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// cloneLoot performs a deep copy of a loot bag.
+func cloneLoot(loot action.Loot) action.Loot {
+	dup := loot
+	dup.Items = append([]action.LootItem(nil), loot.Items...)
+	if loot.Cash != nil {
+		cash := *loot.Cash
+		dup.Cash = &cash
+	}
+
+	return dup
+}
+
+// SetLoot replaces the contents of an existing loot bag.
+func SetLoot(state *decode.DecodeState, zip defs.BlockZIP, selector int,
+	loot action.Loot) error {
+
+	block, err := blockAt(state, zip)
+	if err != nil {
+		return err
+	}
+
+	loots := block.ActionTables.Loots
+	if selector < 0 || selector >= len(loots) || loots[selector] == nil {
+		return wlerr.Errorf("failed to set loot: block=%+v selector=%d: "+
+			"no such loot bag", zip, selector)
+	}
+
+	*loots[selector] = cloneLoot(loot)
+
+	return nil
+}
+
+// SetMonster replaces a monster definition.
+func SetMonster(state *decode.DecodeState, zip defs.BlockZIP, idx int,
+	name decode.MonsterName, data decode.MonsterDataElem) error {
+
+	block, err := blockAt(state, zip)
+	if err != nil {
+		return err
+	}
+
+	if idx < 0 || idx >= len(block.MonsterData.Monsters) ||
+		idx >= len(block.MonsterNames.Names) {
+
+		return wlerr.Errorf("failed to set monster: block=%+v idx=%d: "+
+			"no such monster", zip, idx)
+	}
+
+	block.MonsterNames.Names[idx] = name
+	block.MonsterData.Monsters[idx] = data
+
+	return nil
+}
+
+// SetNPC replaces an NPC.  The replacement must be the same size as the
+// original.
+func SetNPC(state *decode.DecodeState, zip defs.BlockZIP, idx int,
+	npc decode.NPC) error {
+
+	block, err := blockAt(state, zip)
+	if err != nil {
+		return err
+	}
+
+	npcs := block.NPCTable.NPCs
+	if idx < 0 || idx >= len(npcs) {
+		return wlerr.Errorf("failed to set NPC: block=%+v idx=%d: "+
+			"no such NPC", zip, idx)
+	}
+	if len(npc.Data) != decode.NPCSize {
+		return wlerr.Errorf("failed to set NPC: block=%+v idx=%d: "+
+			"invalid size: have=%d want=%d",
+			zip, idx, len(npc.Data), decode.NPCSize)
+	}
+
+	npcs[idx] = decode.NPC{
+		Data: append([]byte{}, npc.Data...),
+	}
+
+	return nil
+}
+
+// SetActionTableElem replaces an element of an undecoded action table.
+func SetActionTableElem(state *decode.DecodeState, zip defs.BlockZIP,
+	tableIdx int, selector int, data []byte) error {
+
+	block, err := blockAt(state, zip)
+	if err != nil {
+		return err
+	}
+
+	t, err := genTable(&block.ActionTables, tableIdx)
+	if err != nil {
+		return err
+	}
+
+	if selector < 0 || selector >= len(t.Elems) || len(t.Elems[selector]) == 0 {
+		return wlerr.Errorf("failed to set action table element: "+
+			"block=%+v table=%d selector=%d: no such element",
+			zip, tableIdx, selector)
+	}
+
+	t.Elems[selector] = append([]byte{}, data...)
+
+	return nil
+}
+
+// lootTerminator ends the element list of an encoded loot bag.  wllib's
+// EncodeLoot does not write it, but DecodeLoot requires it.
+const lootTerminator = 0xff
+
+// encodeLoot encodes a single loot bag, including its terminator.
+func encodeLoot(loot action.Loot) []byte {
+	return append(action.EncodeLoot(loot), lootTerminator)
+}
+
+// lootsEqual indicates whether two loot bags have the same contents.  The
+// position of the cash element among the items is not significant.
+func lootsEqual(a *action.Loot, b *action.Loot) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if a.ToClass != b.ToClass || a.ToSelector != b.ToSelector ||
+		len(a.Items) != len(b.Items) {
+
+		return false
+	}
+	for i, item := range a.Items {
+		if item != b.Items[i] {
+			return false
+		}
+	}
+
+	if a.Cash == nil || b.Cash == nil {
+		return a.Cash == nil && b.Cash == nil
+	}
+
+	return *a.Cash == *b.Cash
+}
+
+// encodeLoots encodes a loot action table.  baseOff is the value of the
+// table's central directory pointer.  The result is decoded and compared
+// against the input before it is returned, so that a table which would not
+// survive a round trip is never written.
+func encodeLoots(loots []*action.Loot, baseOff int) ([]byte, error) {
+	t := gen.Table{}
+	for _, loot := range loots {
+		var elem []byte
+		if loot != nil {
+			elem = encodeLoot(*loot)
+		}
+		t.Elems = append(t.Elems, elem)
+	}
+
+	data := t.Encode(baseOff)
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	wrapErr := func(err error, format string, args ...interface{}) error {
+		return wlerr.Wrapf(err, "loot table failed round trip: "+format,
+			args...)
+	}
+
+	pt, err := gen.ParseTable(data, baseOff)
+	if err != nil {
+		return nil, wrapErr(err, "")
+	}
+	decoded, err := action.DecodeLootTable(*pt)
+	if err != nil {
+		return nil, wrapErr(err, "")
+	}
+
+	if len(decoded) != len(loots) {
+		return nil, wrapErr(nil, "have=%d loots want=%d",
+			len(decoded), len(loots))
+	}
+	for i, loot := range loots {
+		if !lootsEqual(decoded[i], loot) {
+			return nil, wrapErr(nil, "lootidx=%d: have=%+v want=%+v",
+				i, decoded[i], loot)
+		}
+	}
+
+	return data, nil
+}
+
+// commitBlockSections writes the loots, undecoded action tables, NPCs,
+// special actions, and monsters of a decoded block to its MSQ block.  Only
+// sections that differ from the MSQ block's current contents are written.  A
+// section cannot grow beyond its original size.
+func commitBlockSections(block *msq.Block, dim gen.Point,
+	db decode.Block) error {
+
+	orig, err := decode.DecodeBlock(*block, dim)
+	if err != nil {
+		return err
+	}
+
+	write := func(name string, off int, size int, cur []byte,
+		next []byte) error {
+
+		if bytes.Equal(cur, next) {
+			return nil
+		}
+
+		if len(next) > size {
+			return wlerr.Errorf(
+				"failed to replace %s: replacement larger than original: "+
+					"old=%d new=%d", name, size, len(next))
+		}
+
+		copy(block.EncSection[off:off+len(next)], next)
+		return nil
+	}
+
+	lootOff := orig.CentralDir.ActionTables[IDLoot]
+	origLoots, err := encodeLoots(orig.ActionTables.Loots, lootOff)
+	if err != nil {
+		return err
+	}
+	dbLoots, err := encodeLoots(db.ActionTables.Loots, lootOff)
+	if err != nil {
+		return err
+	}
+	if err := write("action loots",
+		orig.Offsets.ActionTables[IDLoot], orig.Sizes.ActionTables[IDLoot],
+		origLoots, dbLoots); err != nil {
+
+		return err
+	}
+
+	for i := 0; i < len(orig.CentralDir.ActionTables); i++ {
+		if i == IDLoot || i == action.IDTransition {
+			continue
+		}
+
+		origT, err := genTable(&orig.ActionTables, i)
+		if err != nil {
+			return err
+		}
+		dbT, err := genTable(&db.ActionTables, i)
+		if err != nil {
+			return err
+		}
+
+		baseOff := orig.CentralDir.ActionTables[i]
+		if err := write(fmt.Sprintf("action table %d", i),
+			orig.Offsets.ActionTables[i], orig.Sizes.ActionTables[i],
+			origT.Encode(baseOff), dbT.Encode(baseOff)); err != nil {
+
+			return err
+		}
+	}
+
+	if len(orig.NPCTable.NPCs) > 0 {
+		baseOff := orig.CentralDir.NPCTable
+		if err := write("NPC table",
+			orig.Offsets.NPCTable, orig.Sizes.NPCTable,
+			decode.EncodeNPCTable(orig.NPCTable, baseOff),
+			decode.EncodeNPCTable(db.NPCTable, baseOff)); err != nil {
+
+			return err
+		}
+	}
+
+	if err := write("special actions",
+		orig.Offsets.SpecialActions, orig.Sizes.SpecialActions,
+		decode.EncodeSpecialActions(orig.SpecialActions),
+		decode.EncodeSpecialActions(db.SpecialActions)); err != nil {
+
+		return err
+	}
+
+	// Monster names are replaced only if the encoded size is unchanged;
+	// otherwise stale bytes from the original would remain at the end.
+	curMN := decode.EncodeMonsterNames(orig.MonsterNames)
+	nextMN := decode.EncodeMonsterNames(db.MonsterNames)
+	if len(curMN) != len(nextMN) {
+		return wlerr.Errorf(
+			"failed to replace monster names: size changed: old=%d new=%d",
+			len(curMN), len(nextMN))
+	}
+	if err := write("monster names",
+		orig.Offsets.MonsterNames, orig.Sizes.MonsterNames,
+		curMN, nextMN); err != nil {
+
+		return err
+	}
+
+	if err := write("monster data",
+		orig.Offsets.MonsterData, orig.Sizes.MonsterData,
+		decode.EncodeMonsterData(orig.MonsterData),
+		decode.EncodeMonsterData(db.MonsterData)); err != nil {
+
+		return err
+	}
+
+	return nil
+}
+
+// CommitActionTables writes the loots, undecoded action tables, NPCs, special
+// actions, and monsters in the given decode state to a set of MSQ blocks.  It
+// is the counterpart of wlutil.CommitDecodeState, which only commits
+// transitions.
+func CommitActionTables(state decode.DecodeState,
+	blocks1 []msq.Block, blocks2 []msq.Block) error {
+
+	commitGame := func(dbs []decode.Block, blocks []msq.Block,
+		dims []gen.Point) error {
+
+		for i, db := range dbs {
+			if err := commitBlockSections(&blocks[i], dims[i], db); err != nil {
+				return wlerr.Wrapf(err, "block=%d", i)
+			}
+		}
+
+		return nil
+	}
+
+	if err := commitGame(state.Blocks[0], blocks1, defs.MapDims[0]); err != nil {
+		return err
+	}
+
+	if err := commitGame(state.Blocks[1], blocks2, defs.MapDims[1]); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package wlmanip
+
+import (
+	"strings"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+// TransClass is a set of flags describing a transition.  See Classify.
+type TransClass uint32
+
+const (
+	// The destination has a transition back to the source.
+	TransClassRoundTrip TransClass = 1 << iota
+
+	// The destination has no transition back to the source.
+	TransClassOneWay
+
+	// The destination is shallower than the source (see LocationDepthMap).
+	// "Previous" transitions and transitions to derelict buildings are
+	// considered to lead all the way up.
+	TransClassUpward
+
+	// The destination is deeper than the source.
+	TransClassDownward
+
+	// The destination has the same depth as the source.
+	TransClassLateral
+
+	// The transition is listed in IntraTransitions.
+	TransClassIntra
+
+	// The transition leads elsewhere in the same block without entering a
+	// sub-location (i.e., it is discarded by CollectCfg.KeepAutoIntra).
+	TransClassAutoIntra
+
+	// The transition leads to or from the world map.
+	TransClassWorld
+
+	TransClassShop
+	TransClassDerelict
+	TransClassRelative
+	TransClassPrevious
+
+	// The transition leads into a sub-location.
+	TransClassSubLocEntry
+
+	// The transition leads out of a sub-location.
+	TransClassSubLocExit
+)
+
+var transClassNames = []string{
+	"round-trip",
+	"one-way",
+	"upward",
+	"downward",
+	"lateral",
+	"intra",
+	"auto-intra",
+	"world",
+	"shop",
+	"derelict",
+	"relative",
+	"previous",
+	"subloc-entry",
+	"subloc-exit",
+}
+
+// Has indicates whether every one of the given flags is set.
+func (c TransClass) Has(flags TransClass) bool {
+	return c&flags == flags
+}
+
+func (c TransClass) String() string {
+	var names []string
+	for i, name := range transClassNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Classify computes the set of classes that a transition belongs to.  Round
+// trips are determined from the collection's unfiltered transitions.  A
+// transition receives no depth class (upward, downward, or lateral) if either
+// end has no depth.  Locations are looked up in the registry built by
+// Collect.
+func Classify(coll *Collection, e *TransEntry) TransClass {
+	var c TransClass
+
+	from := e.FromExactLoc
+	to := e.ToExactLoc
+
+	if len(coll.unfiltered[to][from]) > 0 {
+		c |= TransClassRoundTrip
+	} else {
+		c |= TransClassOneWay
+	}
+
+	isPrevious := e.Trans.Location == defs.LocationPrevious
+	isDerelict := e.Trans.IsDerelict()
+
+	locs := coll.locs
+	if fromDepth, err := locs.Depth(from); err == nil {
+		toDepth := 0
+		if !isPrevious && !isDerelict {
+			toDepth, err = locs.Depth(to)
+		}
+
+		if err == nil {
+			switch {
+			case toDepth < fromDepth:
+				c |= TransClassUpward
+			case toDepth > fromDepth:
+				c |= TransClassDownward
+			default:
+				c |= TransClassLateral
+			}
+		}
+	}
+
+	if TransitionIsIntra(defs.LocPair{e.FromLoc, e.Trans.Location}) {
+		c |= TransClassIntra
+	}
+
+	if e.Trans.Location == e.FromLoc {
+		pair := selectorToSubLocs(e.Desc())
+		if pair.From == -1 && pair.To == -1 {
+			c |= TransClassAutoIntra
+		}
+	}
+
+	if e.FromBlock.GameIdx == 0 && e.FromBlock.BlockIdx == defs.Block0WorldMap ||
+		e.Trans.Location == defs.LocationWorldMap {
+
+		c |= TransClassWorld
+	}
+
+	if e.Trans.ToClass == action.IDShop {
+		c |= TransClassShop
+	}
+	if isDerelict {
+		c |= TransClassDerelict
+	}
+	if e.Trans.Relative {
+		c |= TransClassRelative
+	}
+	if isPrevious {
+		c |= TransClassPrevious
+	}
+
+	if from != to {
+		if locs.IsSubLocation(to) {
+			c |= TransClassSubLocEntry
+		}
+		if locs.IsSubLocation(from) {
+			c |= TransClassSubLocExit
+		}
+	}
+
+	return c
+}
+
+// TransQuery selects transitions by class.
+type TransQuery struct {
+	Include TransClass // Every one of these flags must be set.
+	Exclude TransClass // None of these flags may be set.
+
+	// Search the filtered transitions rather than the unfiltered ones.
+	Filtered bool
+}
+
+// Matches indicates whether a class satisfies a query.
+func (q TransQuery) Matches(c TransClass) bool {
+	return c.Has(q.Include) && c&q.Exclude == 0
+}
+
+// Query retrieves the transitions from the given exact location that satisfy
+// a query.  The returned entries are sorted by game, block, and selector.
+func (c *Collection) Query(from int, q TransQuery) []*TransEntry {
+	m := c.unfiltered
+	if q.Filtered {
+		m = c.filtered
+	}
+
+	var entries []*TransEntry
+	for _, to := range sortedToLocs(m[from]) {
+		for _, e := range m[from][to] {
+			if q.Matches(Classify(c, e)) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	sortTransEntries(entries)
+
+	return entries
+}
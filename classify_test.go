@@ -0,0 +1,91 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestClassify(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	state := testState()
+
+	// Give Downtown West a one way exit straight to the world map.
+	worldExit := SubLocDesc{0, defs.Block0NeedlesDowntownWest, 3}
+	ts := &state.Blocks[0][defs.Block0NeedlesDowntownWest].ActionTables.Transitions
+	for len(*ts) <= worldExit.Selector {
+		*ts = append(*ts, nil)
+	}
+	(*ts)[worldExit.Selector] = &action.Transition{
+		LocX:     10,
+		LocY:     10,
+		Location: defs.LocationWorldMap,
+	}
+
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("failed to apply fixups: %v", err)
+	}
+
+	coll, err := Collect(state, CollectCfg{
+		KeepWorld:      true,
+		KeepPostSewers: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	wants := map[SubLocDesc]TransClass{
+		SubLocDesc{0, defs.Block0Needles, 11}: TransClassRoundTrip |
+			TransClassDownward,
+		SubLocDesc{0, defs.Block0NeedlesDowntownEast, 0}: TransClassRoundTrip |
+			TransClassUpward,
+		SubLocDesc{1, defs.Block1LasVegas, 3}: TransClassRoundTrip |
+			TransClassDownward | TransClassSubLocEntry,
+		SubLocDesc{1, defs.Block1FatFreddys, 5}: TransClassRoundTrip |
+			TransClassUpward | TransClassSubLocExit,
+		worldExit: TransClassOneWay | TransClassUpward | TransClassWorld,
+	}
+
+	seen := map[SubLocDesc]bool{}
+	for _, from := range sortedLocs(coll.unfiltered) {
+		for _, e := range coll.Query(from, TransQuery{}) {
+			want, ok := wants[e.Desc()]
+			if !ok {
+				continue
+			}
+			seen[e.Desc()] = true
+
+			if have := Classify(coll, e); have != want {
+				t.Errorf("%s: have=%s want=%s",
+					SubLocDescString(e.Desc()), have, want)
+			}
+		}
+	}
+
+	for desc, _ := range wants {
+		if !seen[desc] {
+			t.Errorf("%s: not collected", SubLocDescString(desc))
+		}
+	}
+
+	// Get1WayUp is a query over these classes.
+	up := coll.Get1WayUp(defs.LocationNeedlesDowntownWest)
+	if len(up) != 1 || up[0].Desc() != worldExit {
+		var descs []string
+		for _, e := range up {
+			descs = append(descs, SubLocDescString(e.Desc()))
+		}
+		t.Errorf("one way up: have=%v want=[%s]", descs,
+			SubLocDescString(worldExit))
+	}
+
+	down := coll.Query(defs.LocationNeedles, TransQuery{
+		Include: TransClassDownward,
+		Exclude: TransClassWorld,
+	})
+	if len(down) != 2 {
+		t.Errorf("downward from Needles: have=%d want=2", len(down))
+	}
+}
@@ -0,0 +1,137 @@
+package wlmanip
+
+import (
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/gen"
+)
+
+func cloneInts(ints []int) []int {
+	if ints == nil {
+		return nil
+	}
+	return append([]int{}, ints...)
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func cloneMeta(m decode.Meta) decode.Meta {
+	dup := m
+	dup.ActionTables = cloneInts(m.ActionTables)
+	return dup
+}
+
+func cloneGenTable(t gen.Table) gen.Table {
+	dup := gen.Table{}
+	for _, e := range t.Elems {
+		dup.Elems = append(dup.Elems, cloneBytes(e))
+	}
+	return dup
+}
+
+// CloneActionTables performs a deep copy of a block's action tables.
+func CloneActionTables(tables action.Tables) action.Tables {
+	dup := action.Tables{
+		T0:  cloneGenTable(tables.T0),
+		T1:  cloneGenTable(tables.T1),
+		T2:  cloneGenTable(tables.T2),
+		T3:  cloneGenTable(tables.T3),
+		T4:  cloneGenTable(tables.T4),
+		T6:  cloneGenTable(tables.T6),
+		T7:  cloneGenTable(tables.T7),
+		T8:  cloneGenTable(tables.T8),
+		T9:  cloneGenTable(tables.T9),
+		T11: cloneGenTable(tables.T11),
+		T12: cloneGenTable(tables.T12),
+		T13: cloneGenTable(tables.T13),
+		T14: cloneGenTable(tables.T14),
+		T15: cloneGenTable(tables.T15),
+	}
+
+	for _, loot := range tables.Loots {
+		var ldup *action.Loot
+		if loot != nil {
+			l := cloneLoot(*loot)
+			ldup = &l
+		}
+		dup.Loots = append(dup.Loots, ldup)
+	}
+
+	for _, t := range tables.Transitions {
+		var tdup *action.Transition
+		if t != nil {
+			tval := *t
+			tdup = &tval
+		}
+		dup.Transitions = append(dup.Transitions, tdup)
+	}
+
+	return dup
+}
+
+// CloneBlock performs a deep copy of a decoded MSQ block.
+func CloneBlock(block decode.Block) decode.Block {
+	dup := block
+
+	dup.Offsets = cloneMeta(block.Offsets)
+	dup.Sizes = cloneMeta(block.Sizes)
+
+	dup.MapData = decode.MapData{}
+	for _, row := range block.MapData.ActionClasses {
+		dup.MapData.ActionClasses = append(dup.MapData.ActionClasses,
+			cloneInts(row))
+	}
+	for _, row := range block.MapData.ActionSelectors {
+		dup.MapData.ActionSelectors = append(dup.MapData.ActionSelectors,
+			cloneInts(row))
+	}
+
+	dup.CentralDir.ActionTables = cloneInts(block.CentralDir.ActionTables)
+	dup.MapInfo.StringIDs = cloneInts(block.MapInfo.StringIDs)
+	dup.ActionTables = CloneActionTables(block.ActionTables)
+
+	dup.NPCTable = decode.NPCTable{}
+	for _, npc := range block.NPCTable.NPCs {
+		dup.NPCTable.NPCs = append(dup.NPCTable.NPCs, decode.NPC{
+			Data: cloneBytes(npc.Data),
+		})
+	}
+
+	dup.SpecialActions.Actions = cloneBytes(block.SpecialActions.Actions)
+
+	if block.MonsterNames.Names != nil {
+		dup.MonsterNames.Names = append([]decode.MonsterName{},
+			block.MonsterNames.Names...)
+	}
+	if block.MonsterData.Monsters != nil {
+		dup.MonsterData.Monsters = append([]decode.MonsterDataElem{},
+			block.MonsterData.Monsters...)
+	}
+
+	dup.StringsArea.CharTable = cloneBytes(block.StringsArea.CharTable)
+	dup.StringsArea.Pointers = cloneInts(block.StringsArea.Pointers)
+	dup.StringsArea.StringData = cloneBytes(block.StringsArea.StringData)
+
+	return dup
+}
+
+// CloneDecodeState performs a deep copy of a decode state.  The copy can be
+// modified without affecting the original.
+func CloneDecodeState(state decode.DecodeState) decode.DecodeState {
+	dup := decode.DecodeState{
+		Blocks: make([][]decode.Block, len(state.Blocks)),
+	}
+
+	for gameIdx, blocks := range state.Blocks {
+		for _, block := range blocks {
+			dup.Blocks[gameIdx] = append(dup.Blocks[gameIdx], CloneBlock(block))
+		}
+	}
+
+	return dup
+}
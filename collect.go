@@ -8,6 +8,8 @@ import (
 	"github.com/badvassal/wllib/decode"
 	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
 )
 
 // TransEntry represents a single transition.  It is annotated with some extra
@@ -23,6 +25,15 @@ type TransEntry struct {
 	ToExactLoc   int
 }
 
+// Desc returns the block and selector that identify a transition entry.
+func (e *TransEntry) Desc() SubLocDesc {
+	return SubLocDesc{
+		GameIdx:  e.FromBlock.GameIdx,
+		BlockIdx: e.FromBlock.BlockIdx,
+		Selector: e.Selector,
+	}
+}
+
 // LocPairMap maintains the full set of transitions among all MSQ blocks.
 // [exact-from-loc][exact-to-loc].
 type LocPairMap map[int]map[int][]*TransEntry
@@ -32,8 +43,15 @@ type LocPairMap map[int]map[int][]*TransEntry
 type Collection struct {
 	unfiltered LocPairMap // All transitions.
 	filtered   LocPairMap // Filtered by a CollectCfg.
+
+	locs *LocationRegistry // Built by Collect.
 }
 
+// TransFilter is a user-supplied transition filter.  It indicates whether a
+// transition should be kept.  If the transition is discarded, the second
+// return value describes why.
+type TransFilter func(entry TransEntry) (bool, string)
+
 // CollectCfg specifies which transitions to keep and which to filter.
 type CollectCfg struct {
 	KeepWorld          bool
@@ -44,11 +62,18 @@ type CollectCfg struct {
 	KeepAutoIntra      bool
 	KeepHardcodedIntra bool
 	KeepPostSewers     bool
+
+	// Additional filters, evaluated after the built-in checks.  A transition
+	// is kept only if every filter keeps it.  Filters are not saved by
+	// SaveCollectCfg or encoded by EncodeSettings.
+	Filters []TransFilter `json:"-"`
 }
 
 // shouldKeepTransition indicates whether a given transition should be kept
 // according to a CollectCfg.
-func shouldKeepTransition(entry TransEntry, cfg CollectCfg) bool {
+func shouldKeepTransition(entry TransEntry, cfg CollectCfg,
+	locs *LocationRegistry) bool {
+
 	logDiscard := func(reason string) {
 		log.Debugf("discarding transition (%s -> %s) %+v: %s",
 			LocationString(entry.FromExactLoc),
@@ -56,6 +81,11 @@ func shouldKeepTransition(entry TransEntry, cfg CollectCfg) bool {
 			entry, reason)
 	}
 
+	if reason, ok := SelectorIsProtected(entry.Desc()); ok {
+		logDiscard("protected: " + reason)
+		return false
+	}
+
 	if !cfg.KeepWorld {
 		if entry.FromBlock.GameIdx == 0 &&
 			entry.FromBlock.BlockIdx == defs.Block0WorldMap {
@@ -91,18 +121,13 @@ func shouldKeepTransition(entry TransEntry, cfg CollectCfg) bool {
 		return false
 	}
 
-	if !cfg.KeepPostSewers && LocationPostSewersMap[entry.Trans.Location] {
+	if !cfg.KeepPostSewers && locs.IsPostSewers(entry.Trans.Location) {
 		logDiscard("post sewers")
 		return false
 	}
 
 	if !cfg.KeepAutoIntra && entry.Trans.Location == entry.FromLoc {
-		desc := SubLocDesc{
-			GameIdx:  entry.FromBlock.GameIdx,
-			BlockIdx: entry.FromBlock.BlockIdx,
-			Selector: entry.Selector,
-		}
-		loc := selectorToSubLocs(desc)
+		loc := selectorToSubLocs(entry.Desc())
 		if loc.From == -1 && loc.To == -1 {
 			logDiscard("auto intra filter")
 			return false
@@ -116,6 +141,13 @@ func shouldKeepTransition(entry TransEntry, cfg CollectCfg) bool {
 		return false
 	}
 
+	for _, f := range cfg.Filters {
+		if keep, reason := f(entry); !keep {
+			logDiscard("custom filter: " + reason)
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -191,10 +223,85 @@ func collectTransitions(state decode.DecodeState, cfg CollectCfg) ([]*TransEntry
 	return append(es0, es1...), nil
 }
 
+// checkPrepared verifies that none of the active data version's enabled
+// fixups is pending; that is, unapplied while its transitions are still in
+// their vanilla state.  A fixup whose transitions have since been rewritten
+// by an op is considered done.
+func checkPrepared(state decode.DecodeState) error {
+	for _, name := range ActiveDataVersion().Fixups {
+		f, err := LookupFixup(name)
+		if err != nil {
+			return err
+		}
+		if !f.Enabled || f.Applied(state) {
+			continue
+		}
+		if f.Check(state) == nil {
+			return wlerr.Errorf(
+				"state not prepared: fixup %s has not been applied "+
+					"(see PrepareState)", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// landingExactLoc determines the exact location that a transition leads to
+// from the value written in it: the landing point is assigned to an exact
+// location with the destination block's tile anchors (see tileAnchors and
+// tileExactLoc).  Unlike SubLocMap, which is keyed by the transition's own
+// selector, this remains accurate after a shuffle has rewritten the
+// transition.  anchorMap caches the anchors of each destination.
+func landingExactLoc(state decode.DecodeState, locs *LocationRegistry,
+	anchorMap map[int][]locAnchor, t action.Transition) int {
+
+	if t.Relative || t.Location == defs.LocationPrevious || t.IsDerelict() ||
+		len(locs.Children(t.Location)) == 0 {
+
+		return t.Location
+	}
+
+	zip := defs.LocationBlockZIPMap[t.Location]
+	if zip == nil {
+		return t.Location
+	}
+
+	anchors, ok := anchorMap[t.Location]
+	if !ok {
+		anchors = tileAnchors(state, *zip, t.Location)
+		anchorMap[t.Location] = anchors
+	}
+
+	return tileExactLoc(anchors, t.Location, gen.Point{X: t.LocX, Y: t.LocY})
+}
+
+// collectWrittenTransitions is like collectTransitions, but each entry's
+// exact destination is derived from the transition as written (see
+// landingExactLoc) rather than from SubLocMap.  It is suitable for examining
+// a shuffled state.
+func collectWrittenTransitions(state decode.DecodeState,
+	locs *LocationRegistry) ([]*TransEntry, error) {
+
+	entries, err := collectTransitions(state, CollectCfg{})
+	if err != nil {
+		return nil, err
+	}
+
+	anchorMap := map[int][]locAnchor{}
+	for _, e := range entries {
+		e.ToExactLoc = landingExactLoc(state, locs, anchorMap, e.Trans)
+	}
+
+	return entries, nil
+}
+
 // Collect gathers the transitions from among all MSQ blocks and constructs a
-// Collection.
+// Collection.  The transitions are collected from the state as it is, using
+// the tables of the active data version.  The state must have been prepared
+// with PrepareState; an error is returned if any of the active version's
+// enabled fixups is still pending.  The caller's state is not modified.
 func Collect(state decode.DecodeState, cfg CollectCfg) (*Collection, error) {
-	if err := FixupTransitions(&state); err != nil {
+	if err := checkPrepared(state); err != nil {
 		return nil, err
 	}
 
@@ -206,6 +313,7 @@ func Collect(state decode.DecodeState, cfg CollectCfg) (*Collection, error) {
 	coll := &Collection{
 		unfiltered: map[int]map[int][]*TransEntry{},
 		filtered:   map[int]map[int][]*TransEntry{},
+		locs:       NewLocationRegistry(),
 	}
 
 	add := func(m LocPairMap, e *TransEntry) {
@@ -219,13 +327,14 @@ func Collect(state decode.DecodeState, cfg CollectCfg) (*Collection, error) {
 	for _, e := range entries {
 		add(coll.unfiltered, e)
 
-		if shouldKeepTransition(*e, cfg) {
+		if shouldKeepTransition(*e, cfg, coll.locs) {
 			add(coll.filtered, e)
 		}
 	}
 
-	for from, m := range coll.filtered {
-		for to, _ := range m {
+	for _, from := range sortedLocs(coll.filtered) {
+		m := coll.filtered[from]
+		for _, to := range sortedToLocs(m) {
 			if coll.filtered[to][from] == nil {
 				log.Debugf("discarding entry: %s --> %s: no round trip",
 					LocationFullString(from), LocationFullString(to))
@@ -238,6 +347,14 @@ func Collect(state decode.DecodeState, cfg CollectCfg) (*Collection, error) {
 		}
 	}
 
+	// Every remaining location is both a source and a destination, so
+	// checking the sources covers all of them.
+	for _, from := range sortedLocs(coll.filtered) {
+		if _, err := coll.locs.Depth(from); err != nil {
+			return nil, err
+		}
+	}
+
 	return coll, nil
 }
 
@@ -259,26 +376,45 @@ func (c *Collection) GetUnfiltered(lp defs.LocPair) []*TransEntry {
 	return m[lp.To]
 }
 
+// sortedLocs returns the "from" locations in a LocPairMap in ascending order.
+func sortedLocs(m LocPairMap) []int {
+	locs := make([]int, 0, len(m))
+	for loc, _ := range m {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+
+	return locs
+}
+
+// sortedToLocs returns the "to" locations in a single LocPairMap row in
+// ascending order.
+func sortedToLocs(m map[int][]*TransEntry) []int {
+	locs := make([]int, 0, len(m))
+	for loc, _ := range m {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+
+	return locs
+}
+
+// sortTransEntries orders a set of transitions by game, block, and selector.
+func sortTransEntries(entries []*TransEntry) {
+	sort.SliceStable(entries, func(i int, j int) bool {
+		return subLocDescLess(entries[i].Desc(), entries[j].Desc())
+	})
+}
+
 // Get1WayUp retrieves the set of unfiltered transitions from the given
 // location that have the following properties:
 // 1. Lead to a lesser depth location, and
 // 2. Are one way (no return trip).
+// The returned entries are sorted by game, block, and selector.
 func (c *Collection) Get1WayUp(from int) []*TransEntry {
-	var entries []*TransEntry
-
-	for to, m := range c.unfiltered[from] {
-		// Only consider upward transitions.
-		if LocationDepthMap[to] < LocationDepthMap[from] {
-			// Only consider one-way transitions.
-			if len(c.unfiltered[to][from]) == 0 {
-				for _, es := range m {
-					entries = append(entries, es)
-				}
-			}
-		}
-	}
-
-	return entries
+	return c.Query(from, TransQuery{
+		Include: TransClassOneWay | TransClassUpward,
+	})
 }
 
 // FilteredRoundTrips retrieves the set of round trip transitions from the
@@ -292,11 +428,14 @@ func (c *Collection) FilteredRoundTrips() []defs.LocPair {
 	// the caller.
 	seen := map[defs.LocPair]struct{}{}
 
-	for from, m := range c.filtered {
-		for to, _ := range m {
+	// Collect ensures that every filtered location has a depth.
+	locs := c.locs
+
+	for _, from := range sortedLocs(c.filtered) {
+		for _, to := range sortedToLocs(c.filtered[from]) {
 
-			fromDepth := LocationDepthMap[from]
-			toDepth := LocationDepthMap[to]
+			fromDepth, _ := locs.Depth(from)
+			toDepth, _ := locs.Depth(to)
 			if toDepth >= fromDepth {
 				if _, ok := seen[defs.LocPair{to, from}]; ok {
 					continue
@@ -0,0 +1,13 @@
+package wlmanip
+
+import (
+	"testing"
+)
+
+func TestCollectUnprepared(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	if _, err := Collect(testState(), CollectCfg{}); err == nil {
+		t.Errorf("collected an unprepared state")
+	}
+}
@@ -0,0 +1,224 @@
+package wlmanip
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// DefaultDecoupledAttempts is the number of decoupled shuffles attempted
+// before giving up if ShuffleCfg.MaxAttempts is 0.
+const DefaultDecoupledAttempts = 100
+
+// ExecTransOpDecoupled modifies a single route according to the specified
+// TransOp.  Unlike ExecTransOp, the return route is left alone:
+//
+// 1. A.From --> A.to   BECOMES   A.From --> B.to
+//
+// An error is returned if the op would overwrite a protected transition (see
+// ProtectedSelectorMap).  In this case, the state is left unmodified.
+func ExecTransOpDecoupled(coll *Collection, state *decode.DecodeState,
+	op TransOp) error {
+
+	aFwd, bFwd, err := decoupledRoutes(coll, op)
+	if err != nil {
+		log.Warnf("ignoring op %+v: %s", op, err.Error())
+		return nil
+	}
+
+	if err := checkProtected(aFwd); err != nil {
+		return wlerr.Wrapf(err, "failed to execute op %+v", op)
+	}
+
+	aZIP := aFwd[0].FromBlock
+	aDB := state.Blocks[aZIP.GameIdx][aZIP.BlockIdx]
+
+	log.Infof("setting route: %s <-- %s", lpString(op.A), lpString(op.B))
+
+	for i, e := range aFwd {
+		src := bFwd[i%len(bFwd)]
+		log.Debugf("replacing %s(%d) with %s(%d) (decoupled)",
+			lpString(op.A), e.Selector, lpString(op.B), src.Selector)
+		CopyTrans(aDB.ActionTables.Transitions[e.Selector], src.Trans)
+	}
+
+	return nil
+}
+
+// decoupledRoutes resolves the routes that a decoupled op reads and writes.
+// An error is returned if either route is missing or fully delisted.
+func decoupledRoutes(coll *Collection,
+	op TransOp) ([]*TransEntry, []*TransEntry, error) {
+
+	// As in ExecTransOp, filter the reads but not the writes.
+	aFwd := delistEntries(coll.GetUnfiltered(op.A), false)
+	bFwd := delistEntries(coll.GetFiltered(op.B), true)
+	if len(aFwd) == 0 || len(bFwd) == 0 {
+		return nil, nil, wlerr.Errorf("no usable route")
+	}
+
+	return aFwd, bFwd, nil
+}
+
+// planDecoupled produces a set of ops that shuffles the forward and reverse
+// routes of the given round trips independently.  The plando placements
+// apply to forward routes.
+func planDecoupled(pairs []defs.LocPair, seed int64,
+	plando *PlandoSpec) ([]TransOp, error) {
+
+	rng := rand.New(rand.NewSource(seed))
+	fwdSeed := rng.Int63()
+	revSeed := rng.Int63()
+
+	fwdOps, err := planPairs(pairs, fwdSeed, plando)
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []defs.LocPair
+	for _, lp := range pairs {
+		revs = append(revs, defs.LocPair{From: lp.To, To: lp.From})
+	}
+
+	revOps, err := planPairs(revs, revSeed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(fwdOps, revOps...), nil
+}
+
+// stuckLocations returns every location in the given state that has no exit
+// toward the world map; that is, to a location with a lesser depth according
+// to LocationDepthMap.  The transitions are examined as they are written;
+// fixups are not applied, and exact destinations are derived from the
+// written values (see landingExactLoc).
+func stuckLocations(state decode.DecodeState,
+	locs *LocationRegistry) ([]int, error) {
+
+	entries, err := collectWrittenTransitions(state, locs)
+	if err != nil {
+		return nil, err
+	}
+
+	hasExit := map[int]bool{}
+	for _, e := range entries {
+		from := e.FromExactLoc
+		fromDepth, err := locs.Depth(from)
+		if err != nil {
+			return nil, err
+		}
+		if fromDepth == 0 {
+			continue
+		}
+		if _, ok := hasExit[from]; !ok {
+			hasExit[from] = false
+		}
+
+		if e.Trans.Location == defs.LocationPrevious || e.Trans.IsDerelict() {
+			continue
+		}
+		if toDepth, err := locs.Depth(e.ToExactLoc); err == nil &&
+			toDepth < fromDepth {
+
+			hasExit[from] = true
+		}
+	}
+
+	var stuck []int
+	for loc, ok := range hasExit {
+		if !ok {
+			stuck = append(stuck, loc)
+		}
+	}
+	sort.Ints(stuck)
+
+	return stuck, nil
+}
+
+// stuckError describes a set of locations without an exit toward the world
+// map.
+func stuckError(stuck []int) error {
+	var strs []string
+	for _, loc := range stuck {
+		strs = append(strs, LocationString(loc))
+	}
+
+	return wlerr.Errorf("locations without an exit toward the world map: %s",
+		strings.Join(strs, ", "))
+}
+
+// ValidateDecoupled verifies that every location in the given state still
+// has at least one exit leading toward the world map; that is, to a location
+// with a lesser depth according to LocationDepthMap.  It returns an error
+// listing every location without such an exit.
+func ValidateDecoupled(state decode.DecodeState) error {
+	stuck, err := stuckLocations(state, NewLocationRegistry())
+	if err != nil {
+		return err
+	}
+	if len(stuck) > 0 {
+		return stuckError(stuck)
+	}
+
+	return nil
+}
+
+// shuffleDecoupled performs a decoupled shuffle.  Shuffles that fail
+// validation are retried with a new seed, up to the configured number of
+// attempts.
+func shuffleDecoupled(coll *Collection, state *decode.DecodeState,
+	pairs []defs.LocPair, cfg ShuffleCfg) ([]TransOp, error) {
+
+	if err := checkPlando(coll, cfg.Plando, true); err != nil {
+		return nil, err
+	}
+
+	attempts := cfg.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultDecoupledAttempts
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		ops, err := planDecoupled(pairs, rng.Int63(), cfg.Plando)
+		if err != nil {
+			return nil, err
+		}
+
+		dup := CloneDecodeState(*state)
+		for _, op := range ops {
+			if err := ExecTransOpDecoupled(coll, &dup, op); err != nil {
+				return nil, err
+			}
+		}
+
+		// Only a failed validation warrants another attempt; any other error
+		// would recur.
+		stuck, err := stuckLocations(dup, coll.locs)
+		if err != nil {
+			return nil, err
+		}
+		if len(stuck) > 0 {
+			lastErr = stuckError(stuck)
+			log.Debugf("decoupled shuffle attempt %d rejected: %s",
+				attempt, lastErr.Error())
+			continue
+		}
+
+		*state = dup
+		return ops, nil
+	}
+
+	return nil, wlerr.Wrapf(lastErr,
+		"failed to produce a valid decoupled shuffle after %d attempts",
+		attempts)
+}
@@ -0,0 +1,55 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestStuckLocationsWrittenDestination(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	state := testState()
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The jail's exit sits at (4,4) in Fat Freddy's; the proton ax room's
+	// exit at (20,20).
+	setTile(state, 1, defs.Block1FatFreddys, 4, 4, action.IDTransition, 6)
+	setTile(state, 1, defs.Block1FatFreddys, 20, 20, action.IDTransition, 5)
+
+	isStuck := func(loc int) bool {
+		stuck, err := stuckLocations(state, NewLocationRegistry())
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, l := range stuck {
+			if l == loc {
+				return true
+			}
+		}
+		return false
+	}
+
+	setTrans(state, 1, defs.Block1FatFreddys, 6, action.Transition{
+		LocX:     40,
+		LocY:     12,
+		Location: defs.LocationLasVegas,
+	})
+	if isStuck(SubLocationLasVegasJail) {
+		t.Errorf("vanilla: jail reported stuck")
+	}
+
+	// Rewrite the jail's exit (which SubLocMap says leads to Las Vegas) so
+	// that it leads back into the jail.
+	setTrans(state, 1, defs.Block1FatFreddys, 6, action.Transition{
+		LocX:     4,
+		LocY:     5,
+		Location: defs.LocationFatFreddys,
+	})
+	if !isStuck(SubLocationLasVegasJail) {
+		t.Errorf("shuffled: jail not reported stuck")
+	}
+}
@@ -0,0 +1,111 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// DepthDiff describes a location whose computed depth differs from its entry
+// in LocationDepthMap.
+type DepthDiff struct {
+	Loc int
+
+	Hardcoded    int
+	HasHardcoded bool
+
+	Computed    int
+	HasComputed bool
+}
+
+func (d DepthDiff) String() string {
+	depthStr := func(depth int, ok bool) string {
+		if !ok {
+			return "none"
+		}
+		return fmt.Sprintf("%d", depth)
+	}
+
+	return fmt.Sprintf("%s: hardcoded=%s computed=%s",
+		LocationFullString(d.Loc),
+		depthStr(d.Hardcoded, d.HasHardcoded),
+		depthStr(d.Computed, d.HasComputed))
+}
+
+// LocationDepth retrieves the depth of the given exact location from
+// LocationDepthMap.  Unlike a plain map lookup, it returns an error if the
+// location has no depth rather than treating it as the world map.
+func LocationDepth(loc int) (int, error) {
+	depth, ok := LocationDepthMap[loc]
+	if !ok {
+		return 0, wlerr.Errorf("location has no depth: %s",
+			LocationFullString(loc))
+	}
+
+	return depth, nil
+}
+
+// ComputeDepths calculates the depth of every location reachable from the
+// world map by a breadth first search over a collection's unfiltered
+// transitions.  "Previous" transitions and transitions to derelict buildings
+// are not followed.
+func ComputeDepths(coll *Collection) map[int]int {
+	depths := map[int]int{
+		defs.LocationWorldMap: 0,
+	}
+
+	queue := []int{defs.LocationWorldMap}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+
+		m := coll.unfiltered[from]
+		for _, to := range sortedToLocs(m) {
+			if _, ok := depths[to]; ok {
+				continue
+			}
+			if to == defs.LocationPrevious || m[to][0].Trans.IsDerelict() {
+				continue
+			}
+
+			depths[to] = depths[from] + 1
+			queue = append(queue, to)
+		}
+	}
+
+	return depths
+}
+
+// CompareDepths compares a set of computed depths (see ComputeDepths)
+// against LocationDepthMap.  It returns one entry per location whose depths
+// differ, sorted by location.
+func CompareDepths(computed map[int]int) []DepthDiff {
+	locSet := map[int]struct{}{}
+	for loc, _ := range computed {
+		locSet[loc] = struct{}{}
+	}
+	for loc, _ := range LocationDepthMap {
+		locSet[loc] = struct{}{}
+	}
+
+	var locs []int
+	for loc, _ := range locSet {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+
+	var diffs []DepthDiff
+	for _, loc := range locs {
+		d := DepthDiff{Loc: loc}
+		d.Hardcoded, d.HasHardcoded = LocationDepthMap[loc]
+		d.Computed, d.HasComputed = computed[loc]
+
+		if d.HasHardcoded != d.HasComputed || d.Hardcoded != d.Computed {
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs
+}
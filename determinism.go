@@ -0,0 +1,82 @@
+package wlmanip
+
+import (
+	"bytes"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/gen/wlerr"
+	"github.com/badvassal/wllib/serialize"
+)
+
+// serializeTransitions encodes every transition table in a decode state, one
+// byte sequence per block.
+func serializeTransitions(state decode.DecodeState) [][][]byte {
+	out := make([][][]byte, len(state.Blocks))
+	for gameIdx, blocks := range state.Blocks {
+		for _, block := range blocks {
+			out[gameIdx] = append(out[gameIdx],
+				serialize.SerializeActionTransitions(
+					block.ActionTables.Transitions, 0))
+		}
+	}
+
+	return out
+}
+
+// RunTransOps collects the transitions in a copy of the given state and
+// executes a sequence of ops against the copy.  The state should have been
+// prepared with PrepareState.  The caller's state is not modified.
+func RunTransOps(state decode.DecodeState, cfg CollectCfg,
+	ops []TransOp) (*decode.DecodeState, error) {
+
+	dup := CloneDecodeState(state)
+
+	coll, err := Collect(dup, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		if err := ExecTransOp(coll, &dup, op); err != nil {
+			return nil, err
+		}
+	}
+
+	return &dup, nil
+}
+
+// CheckDeterminism executes the same set of ops against the given state
+// several times and verifies that every run produces byte-identical
+// transition tables.  It returns an error describing the first difference
+// found.
+func CheckDeterminism(state decode.DecodeState, cfg CollectCfg,
+	ops []TransOp, runs int) error {
+
+	var first [][][]byte
+
+	for run := 0; run < runs; run++ {
+		result, err := RunTransOps(state, cfg, ops)
+		if err != nil {
+			return wlerr.Wrapf(err, "run=%d", run)
+		}
+
+		out := serializeTransitions(*result)
+		if first == nil {
+			first = out
+			continue
+		}
+
+		for gameIdx, blocks := range out {
+			for blockIdx, b := range blocks {
+				if !bytes.Equal(b, first[gameIdx][blockIdx]) {
+					return wlerr.Errorf(
+						"nondeterministic result: run=%d game=%d block=%d: "+
+							"have=%x want=%x",
+						run, gameIdx, blockIdx, b, first[gameIdx][blockIdx])
+				}
+			}
+		}
+	}
+
+	return nil
+}
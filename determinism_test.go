@@ -0,0 +1,117 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+// testState builds a synthetic decode state containing the transitions
+// modified by the original data version's fixups, plus enough return routes
+// to form round trips.
+func testState() decode.DecodeState {
+	state := decode.DecodeState{
+		Blocks: [][]decode.Block{
+			make([]decode.Block, defs.Block0NumBlocks),
+			make([]decode.Block, defs.Block1NumBlocks),
+		},
+	}
+
+	set := func(gameIdx int, blockIdx int, selector int, t action.Transition) {
+		ts := &state.Blocks[gameIdx][blockIdx].ActionTables.Transitions
+		for len(*ts) <= selector {
+			*ts = append(*ts, nil)
+		}
+		(*ts)[selector] = &t
+	}
+
+	// Needles <--> Downtown East and Downtown West.
+	set(0, defs.Block0Needles, 11, action.Transition{
+		Relative: true,
+		LocX:     2,
+		LocY:     0,
+		Location: defs.LocationNeedlesDowntownEast,
+	})
+	set(0, defs.Block0Needles, 20, action.Transition{
+		Relative: true,
+		LocX:     -2,
+		LocY:     0,
+		Location: defs.LocationNeedlesDowntownWest,
+	})
+	set(0, defs.Block0NeedlesDowntownEast, 0, action.Transition{
+		LocX:     40,
+		LocY:     13,
+		Location: defs.LocationNeedles,
+	})
+	set(0, defs.Block0NeedlesDowntownWest, 2, action.Transition{
+		Relative: true,
+		LocX:     1,
+		LocY:     0,
+		Location: defs.LocationNeedles,
+	})
+
+	// Las Vegas <--> proton ax room.
+	set(1, defs.Block1LasVegas, 3, action.Transition{
+		LocX:     5,
+		LocY:     5,
+		Location: defs.LocationFatFreddys,
+	})
+	set(1, defs.Block1FatFreddys, 5, action.Transition{
+		Location: defs.LocationPrevious,
+	})
+
+	return state
+}
+
+func TestCheckDeterminism(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	// The synthetic state has no known fingerprint and is too sparse to be
+	// identified structurally, so the fixups are applied directly rather
+	// than with PrepareState.
+	state := testState()
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("failed to apply fixups: %v", err)
+	}
+
+	cfg := CollectCfg{KeepPostSewers: true}
+	ops := []TransOp{
+		TransOp{
+			A: defs.LocPair{defs.LocationNeedles, defs.LocationNeedlesDowntownEast},
+			B: defs.LocPair{defs.LocationNeedles, defs.LocationNeedlesDowntownWest},
+		},
+		TransOp{
+			A: defs.LocPair{defs.LocationLasVegas, SubLocationLasVegasProtonAxRoom},
+			B: defs.LocPair{defs.LocationNeedles, defs.LocationNeedlesDowntownEast},
+		},
+	}
+
+	if err := CheckDeterminism(state, cfg, ops, 5); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	result, err := RunTransOps(state, cfg, ops)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	desc := SubLocDesc{1, defs.Block1LasVegas, 3}
+	if have := transitionAt(*result, desc).Location; have !=
+		defs.LocationNeedlesDowntownEast {
+
+		t.Errorf("%s: have=%s want=%s", SubLocDescString(desc),
+			LocationString(have),
+			LocationString(defs.LocationNeedlesDowntownEast))
+	}
+
+	// The caller's state must not be modified.
+	if have := transitionAt(state, desc).Location; have !=
+		defs.LocationFatFreddys {
+
+		t.Errorf("input state modified: %s: have=%s want=%s",
+			SubLocDescString(desc), LocationString(have),
+			LocationString(defs.LocationFatFreddys))
+	}
+}
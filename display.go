@@ -0,0 +1,128 @@
+package wlmanip
+
+import (
+	"fmt"
+
+	"github.com/badvassal/wllib/defs"
+)
+
+// LocationDisplay contains the player-facing name of a location along with
+// alternate names accepted by ResolveLocation.
+type LocationDisplay struct {
+	Name    string
+	Aliases []string
+}
+
+// LocationDisplayMap contains the player-facing names of exact locations.
+// Locations without an entry are displayed using LocationString.
+var LocationDisplayMap = map[int]LocationDisplay{
+	defs.LocationWorldMap:                  {"World Map", nil},
+	defs.LocationQuartz:                    {"Quartz", nil},
+	defs.LocationScottsBar:                 {"Scott's Bar", []string{"scotts"}},
+	defs.LocationStageCoachInn:             {"Stagecoach Inn", nil},
+	defs.LocationUglysHideout:              {"Ugly's Hideout", nil},
+	defs.LocationQuartzDerelictBuildings:   {"Derelict Buildings (Quartz)", nil},
+	defs.LocationCourthouse:                {"Courthouse (Quartz)", nil},
+	defs.LocationSleeperBaseLevel1:         {"Sleeper Base — Level 1", nil},
+	defs.LocationDesertNomads:              {"Desert Nomads", []string{"nomads"}},
+	defs.LocationAgCenter:                  {"Agricultural Center", nil},
+	defs.LocationHighpool:                  {"Highpool", nil},
+	defs.LocationLasVegasDerelictBuildings: {"Derelict Buildings (Las Vegas)", nil},
+	defs.LocationLasVegas:                  {"Las Vegas", []string{"vegas"}},
+	defs.LocationSleeperBaseLevel2:         {"Sleeper Base — Level 2", nil},
+	defs.LocationSleeperBaseLevel3:         {"Sleeper Base — Level 3", nil},
+	defs.LocationBaseCochiseOutside:        {"Base Cochise — Outside", []string{"cochise"}},
+	defs.LocationBaseCochiseLevel1:         {"Base Cochise — Level 1", nil},
+	defs.LocationBaseCochiseLevel2:         {"Base Cochise — Level 2", nil},
+	defs.LocationBaseCochiseLevel3:         {"Base Cochise — Level 3", nil},
+	defs.LocationBaseCochiseLevel4:         {"Base Cochise — Level 4", nil},
+	defs.LocationDarwin:                    {"Darwin Village", nil},
+	defs.LocationDarwinBase:                {"Darwin Base", nil},
+	defs.LocationFinstersBrain:             {"Finster's Brain", nil},
+	defs.LocationLasVegasSewersWest:        {"Las Vegas Sewers — West", []string{"sewers"}},
+	defs.LocationLasVegasSewersEast:        {"Las Vegas Sewers — East", nil},
+	defs.LocationNeedles:                   {"Needles", nil},
+	defs.LocationBloodTempleTop:            {"Temple of Blood — Upper", nil},
+	defs.LocationBloodTempleBottom:         {"Temple of Blood — Lower", nil},
+	defs.LocationVerminCave:                {"Vermin Cave", nil},
+	defs.LocationWastePit:                  {"Waste Pit", nil},
+	defs.LocationNeedlesDowntownEast:       {"Needles Downtown — East", nil},
+	defs.LocationNeedlesDowntownWest:       {"Needles Downtown — West", nil},
+	defs.LocationPoliceStation:             {"Police Station Complex (Needles)", nil},
+	defs.LocationGuardianCitadelEntrance:   {"Guardian Citadel — Entrance", []string{"citadel"}},
+	defs.LocationGuardianCitadelOuter:      {"Guardian Citadel — Outer", nil},
+	defs.LocationGuardianCitadelInner:      {"Guardian Citadel — Inner", nil},
+	defs.LocationTempleMushroom:            {"Temple of the Mushroom", []string{"mushroom"}},
+	defs.LocationFaranBrygos:               {"Faran Brygo's", nil},
+	defs.LocationFatFreddys:                {"Fat Freddy's", []string{"freddys"}},
+	defs.LocationSpadesCasino:              {"Spade's Casino", []string{"casino"}},
+	defs.LocationMineShaft:                 {"Mine Shaft", nil},
+	defs.LocationSavageVillage:             {"Savage Village", nil},
+
+	SubLocationHighpoolCave:            {"Cave (Highpool)", nil},
+	SubLocationHighpoolCommunityCenter: {"Community Center (Highpool)", []string{"community center"}},
+	SubLocationHighpoolWorkshop:        {"Workshop (Highpool)", []string{"workshop"}},
+	SubLocationAgCenterRootCellar:      {"Root Cellar (Agricultural Center)", []string{"root cellar"}},
+	SubLocationDesertNomadsTent:        {"Tent (Desert Nomads)", []string{"tent"}},
+	SubLocationUglysHideoutAlley:       {"Alley (Ugly's Hideout)", []string{"uglys alley"}},
+	SubLocationNeedlesBishopsOffice:    {"Bishop's Office (Needles)", []string{"bishop"}},
+	SubLocationNeedlesGarage:           {"Garage (Needles)", nil},
+	SubLocationNeedlesPoliceStation:    {"Police Station (Needles)", nil},
+	SubLocationNeedlesAmmoBunker:       {"Ammo Bunker (Needles)", []string{"ammo bunker"}},
+	SubLocationDarwinBlackMarket:       {"Black Market (Darwin)", []string{"black market"}},
+	SubLocationDarwinLab:               {"Lab (Darwin)", nil},
+	SubLocationDarwinBlackGilaTavern:   {"Black Gila Tavern (Darwin)", nil},
+	SubLocationLasVegasJail:            {"Jail (Las Vegas)", []string{"vegas jail"}},
+	SubLocationLasVegasProtonAxRoom:    {"Proton Ax Room (Las Vegas)", []string{"proton ax"}},
+	SubLocationSpadesCasinoWineCellar:  {"Spade's Casino — Wine Cellar", []string{"wine cellar"}},
+	SubLocationSpadesCasinoLevel2:      {"Spade's Casino — Level 2", nil},
+	SubLocationSpadesCasinoBasement:    {"Spade's Casino — Basement", nil},
+}
+
+// LocationFormat controls how FormatLocation renders a location.
+type LocationFormat int
+
+const (
+	// LocationFormatID renders the identifier used for parsing (e.g.,
+	// "NeedlesBishopsOffice").  This is the same as LocationString.
+	LocationFormatID LocationFormat = iota
+
+	// LocationFormatDisplay renders the player-facing name (e.g., "Bishop's
+	// Office (Needles)").
+	LocationFormatDisplay
+
+	// LocationFormatCode renders the numeric location code (e.g., "262").
+	LocationFormatCode
+
+	// LocationFormatFull renders the code followed by the player-facing name
+	// (e.g., "262 (Bishop's Office (Needles))").  This is the display
+	// counterpart of LocationFullString.
+	LocationFormatFull
+)
+
+// LocationDisplayName produces the player-facing name of an exact location.
+// It falls back to LocationString if the location has no display name.
+func LocationDisplayName(loc int) string {
+	if d, ok := LocationDisplayMap[loc]; ok && d.Name != "" {
+		return d.Name
+	}
+
+	return LocationString(loc)
+}
+
+// FormatLocation renders an exact location in the given format.
+func FormatLocation(loc int, format LocationFormat) string {
+	switch format {
+	case LocationFormatDisplay:
+		return LocationDisplayName(loc)
+
+	case LocationFormatCode:
+		return fmt.Sprintf("%d", loc)
+
+	case LocationFormatFull:
+		return fmt.Sprintf("%d (%s)", loc, LocationDisplayName(loc))
+
+	default:
+		return LocationString(loc)
+	}
+}
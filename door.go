@@ -0,0 +1,347 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Door is a group of equivalent transition selectors.  A single physical door
+// often corresponds to several selectors (e.g., one per map tile along a
+// wall).  Selectors belong to the same door if they are in the same source
+// block and send the player to the same destination coordinates.
+type Door struct {
+	FromBlock    defs.BlockZIP
+	FromExactLoc int
+	ToExactLoc   int
+
+	Location int       // Destination regular location.
+	Dest     gen.Point // Destination coordinates.
+	Relative bool
+
+	// Entries are the door's transitions, sorted by selector.
+	Entries []*TransEntry
+
+	// Tiles are the map tiles in the source block that trigger the door.  A
+	// door that is only reached through another action has no tiles.
+	Tiles []gen.Point
+
+	// Return is the door that leads back through this one, or nil if this
+	// door is one way.
+	Return *Door
+}
+
+// doorKey identifies the door that a transition belongs to.
+type doorKey struct {
+	FromBlock    defs.BlockZIP
+	FromExactLoc int
+	ToExactLoc   int
+	Location     int
+	Dest         gen.Point
+	Relative     bool
+}
+
+// DoorSet is the set of doors among all MSQ blocks, built from the unfiltered
+// transitions of a Collection.
+type DoorSet struct {
+	doors []*Door                  // Sorted by first selector's descriptor.
+	byLP  map[defs.LocPair][]*Door // [exact-loc-pair]doors
+}
+
+// Selectors returns the door's selectors in ascending order.
+func (d *Door) Selectors() []int {
+	var sels []int
+	for _, e := range d.Entries {
+		sels = append(sels, e.Selector)
+	}
+
+	return sels
+}
+
+// LocPair returns the exact locations that the door connects.
+func (d *Door) LocPair() defs.LocPair {
+	return defs.LocPair{d.FromExactLoc, d.ToExactLoc}
+}
+
+func (d *Door) String() string {
+	var sels []string
+	for _, sel := range d.Selectors() {
+		sels = append(sels, fmt.Sprintf("%d", sel))
+	}
+
+	rel := ""
+	if d.Relative {
+		rel = " (relative)"
+	}
+
+	return fmt.Sprintf("%s --> %s @%d,%d%s [%s]",
+		LocationDisplayName(d.FromExactLoc), LocationDisplayName(d.ToExactLoc),
+		d.Dest.X, d.Dest.Y, rel, strings.Join(sels, ","))
+}
+
+// doorTiles finds the map tiles in the given block that trigger any of the
+// given selectors.
+func doorTiles(state decode.DecodeState, zip defs.BlockZIP,
+	sels []int) []gen.Point {
+
+	if zip.GameIdx < 0 || zip.GameIdx >= len(state.Blocks) ||
+		zip.BlockIdx < 0 || zip.BlockIdx >= len(state.Blocks[zip.GameIdx]) {
+
+		return nil
+	}
+
+	selSet := map[int]struct{}{}
+	for _, sel := range sels {
+		selSet[sel] = struct{}{}
+	}
+
+	var tiles []gen.Point
+
+	md := state.Blocks[zip.GameIdx][zip.BlockIdx].MapData
+	for y, row := range md.ActionClasses {
+		for x, class := range row {
+			if class != action.IDTransition {
+				continue
+			}
+			if _, ok := selSet[md.ActionSelectors[y][x]]; ok {
+				tiles = append(tiles, gen.Point{X: x, Y: y})
+			}
+		}
+	}
+
+	return tiles
+}
+
+// returnDoorDist computes how far the given return door drops the player from
+// the tiles of the door it returns through.  A smaller value indicates a
+// better match.  -1 is returned if the distance cannot be determined.
+func returnDoorDist(d *Door, ret *Door) int {
+	blockLoc, err := defs.BlockZIPToLoc(d.FromBlock)
+	if err != nil || ret.Relative || ret.Location != blockLoc {
+		return -1
+	}
+
+	abs := func(i int) int {
+		if i < 0 {
+			return -i
+		}
+		return i
+	}
+
+	best := -1
+	for _, p := range d.Tiles {
+		dist := abs(p.X-ret.Dest.X) + abs(p.Y-ret.Dest.Y)
+		if best == -1 || dist < best {
+			best = dist
+		}
+	}
+
+	return best
+}
+
+// CollectDoors groups a collection's unfiltered transitions into doors and
+// pairs each door with its return door.  A door's return door is the door
+// leading from its destination back to its source that drops the player
+// closest to the door's tiles.  The state is used to locate door tiles; it
+// should be the state that the collection was built from.
+func CollectDoors(state decode.DecodeState, coll *Collection) *DoorSet {
+	ds := &DoorSet{
+		byLP: map[defs.LocPair][]*Door{},
+	}
+
+	for _, from := range sortedLocs(coll.unfiltered) {
+		for _, to := range sortedToLocs(coll.unfiltered[from]) {
+			entries := append([]*TransEntry(nil), coll.unfiltered[from][to]...)
+			sortTransEntries(entries)
+
+			doorMap := map[doorKey]*Door{}
+			for _, e := range entries {
+				key := doorKey{
+					FromBlock:    e.FromBlock,
+					FromExactLoc: e.FromExactLoc,
+					ToExactLoc:   e.ToExactLoc,
+					Location:     e.Trans.Location,
+					Dest:         gen.Point{X: e.Trans.LocX, Y: e.Trans.LocY},
+					Relative:     e.Trans.Relative,
+				}
+
+				d := doorMap[key]
+				if d == nil {
+					d = &Door{
+						FromBlock:    key.FromBlock,
+						FromExactLoc: key.FromExactLoc,
+						ToExactLoc:   key.ToExactLoc,
+						Location:     key.Location,
+						Dest:         key.Dest,
+						Relative:     key.Relative,
+					}
+					doorMap[key] = d
+
+					lp := defs.LocPair{from, to}
+					ds.byLP[lp] = append(ds.byLP[lp], d)
+					ds.doors = append(ds.doors, d)
+				}
+				d.Entries = append(d.Entries, e)
+			}
+		}
+	}
+
+	for _, d := range ds.doors {
+		d.Tiles = doorTiles(state, d.FromBlock, d.Selectors())
+	}
+
+	for _, d := range ds.doors {
+		best := -1
+		for _, ret := range ds.byLP[defs.LocPair{d.ToExactLoc, d.FromExactLoc}] {
+			dist := returnDoorDist(d, ret)
+			if d.Return == nil || dist != -1 && (best == -1 || dist < best) {
+				d.Return = ret
+				best = dist
+			}
+		}
+	}
+
+	sort.SliceStable(ds.doors, func(i int, j int) bool {
+		return subLocDescLess(ds.doors[i].Entries[0].Desc(),
+			ds.doors[j].Entries[0].Desc())
+	})
+
+	return ds
+}
+
+// All returns every door, sorted by game, block, and first selector.
+func (ds *DoorSet) All() []*Door {
+	return append([]*Door(nil), ds.doors...)
+}
+
+// Get returns the doors leading from one exact location to another.
+func (ds *DoorSet) Get(lp defs.LocPair) []*Door {
+	return append([]*Door(nil), ds.byLP[lp]...)
+}
+
+// Report produces a human readable list of every door and its return door.
+func (ds *DoorSet) Report() string {
+	var lines []string
+	for _, d := range ds.doors {
+		ret := "(one way)"
+		if d.Return != nil {
+			ret = "<-- " + d.Return.String()
+		}
+		lines = append(lines, fmt.Sprintf("%s\n    %s", d.String(), ret))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// DoorOp replaces one door with another.  It is the door-level counterpart of
+// TransOp:
+//
+// 1. A.From --> A.To   BECOMES   A.From --> B.To
+// 2. A.From <-- A.To   BECOMES   A.From <-- B.To
+//
+// Only the selectors of door A, of B's return door, and of B.To's one way
+// upward exits are modified; other doors connecting the same locations are
+// left alone.  As with ExecTransOp, the one way upward exits are rerouted
+// through A's return door so that the player leaves the way he came.
+type DoorOp struct {
+	A *Door
+	B *Door
+}
+
+// firstReadable returns the first entry in a door that may be used as a copy
+// source.
+func firstReadable(d *Door) (*TransEntry, error) {
+	entries := delistEntries(d.Entries, true)
+	if len(entries) == 0 {
+		return nil, wlerr.Errorf("door cannot be copied: %s", d.String())
+	}
+
+	return entries[0], nil
+}
+
+// ExecDoorOp modifies a pair of doors according to the specified DoorOp.  Both
+// doors must have a return door.  The collection should be the one the doors
+// were built from.  As with ExecTransOp, the state should have been prepared
+// with PrepareState, and an error is returned (leaving the state unmodified)
+// if the op would overwrite a protected transition.
+func ExecDoorOp(coll *Collection, state *decode.DecodeState,
+	op DoorOp) error {
+
+	wrapErr := func(err error) error {
+		return wlerr.Wrapf(err, "failed to execute door op: %s <-- %s",
+			op.A.String(), op.B.String())
+	}
+
+	if op.A.Return == nil || op.B.Return == nil {
+		return wrapErr(wlerr.Errorf("door is one way"))
+	}
+
+	src, err := firstReadable(op.B)
+	if err != nil {
+		return wrapErr(err)
+	}
+	retSrc, err := firstReadable(op.A.Return)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	fwd := delistEntries(op.A.Entries, false)
+	rev := delistEntries(op.B.Return.Entries, false)
+	oneWayUp := delistEntries(coll.Get1WayUp(op.B.ToExactLoc), false)
+
+	var writes []*TransEntry
+	writes = append(writes, fwd...)
+	writes = append(writes, rev...)
+	writes = append(writes, oneWayUp...)
+	if err := checkProtected(writes); err != nil {
+		return wrapErr(err)
+	}
+
+	lookup := func(entries []*TransEntry) ([]*action.Transition, error) {
+		var ts []*action.Transition
+		for _, e := range entries {
+			t := transitionAt(*state, e.Desc())
+			if t == nil {
+				return nil, wlerr.Errorf("no such transition: %s",
+					SubLocDescString(e.Desc()))
+			}
+			ts = append(ts, t)
+		}
+		return ts, nil
+	}
+
+	fwdTs, err := lookup(fwd)
+	if err != nil {
+		return wrapErr(err)
+	}
+	revTs, err := lookup(rev)
+	if err != nil {
+		return wrapErr(err)
+	}
+	oneWayUpTs, err := lookup(oneWayUp)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	log.Infof("setting door: %s <-- %s", op.A.String(), op.B.String())
+
+	for _, t := range fwdTs {
+		CopyTrans(t, src.Trans)
+	}
+	for _, t := range revTs {
+		CopyTrans(t, retSrc.Trans)
+	}
+	for _, t := range oneWayUpTs {
+		CopyTrans(t, retSrc.Trans)
+	}
+
+	return nil
+}
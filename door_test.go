@@ -0,0 +1,70 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestExecDoorOpOneWayUp(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	state := testState()
+
+	// Give Downtown West a one way exit straight to the world map.
+	oneWayUp := SubLocDesc{0, defs.Block0NeedlesDowntownWest, 3}
+	ts := &state.Blocks[0][defs.Block0NeedlesDowntownWest].ActionTables.Transitions
+	for len(*ts) <= oneWayUp.Selector {
+		*ts = append(*ts, nil)
+	}
+	(*ts)[oneWayUp.Selector] = &action.Transition{
+		LocX:     10,
+		LocY:     10,
+		Location: defs.LocationWorldMap,
+	}
+
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("failed to apply fixups: %v", err)
+	}
+
+	coll, err := Collect(state, CollectCfg{KeepPostSewers: true})
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	if len(coll.Get1WayUp(defs.LocationNeedlesDowntownWest)) == 0 {
+		t.Fatalf("test state has no one way upward exit")
+	}
+
+	ds := CollectDoors(state, coll)
+	doorA := ds.Get(testPairDTE)
+	doorB := ds.Get(testPairDTW)
+	if len(doorA) != 1 || len(doorB) != 1 {
+		t.Fatalf("unexpected doors: have=%d,%d want=1,1",
+			len(doorA), len(doorB))
+	}
+
+	op := DoorOp{A: doorA[0], B: doorB[0]}
+	if err := ExecDoorOp(coll, &state, op); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The one way exit must now lead back the way the player came: to
+	// Needles, where Downtown East's return door drops him.
+	want := *transitionAt(state, SubLocDesc{0, defs.Block0NeedlesDowntownEast, 0})
+	for _, desc := range []SubLocDesc{
+		SubLocDesc{0, defs.Block0NeedlesDowntownWest, 2},
+		oneWayUp,
+	} {
+		have := transitionAt(state, desc)
+		if have.Location != want.Location ||
+			have.LocX != want.LocX || have.LocY != want.LocY {
+
+			t.Errorf("%s: have=%s@%d,%d want=%s@%d,%d",
+				SubLocDescString(desc),
+				LocationString(have.Location), have.LocX, have.LocY,
+				LocationString(want.Location), want.LocX, want.LocY)
+		}
+	}
+}
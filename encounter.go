@@ -0,0 +1,291 @@
+package wlmanip
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/digest"
+	"github.com/badvassal/wllib/gen"
+)
+
+// IDEncounter is the index of the action table containing encounters.
+const IDEncounter = 4
+
+// An encounter action contains a sequence of monster groups starting at
+// encounterGroupsOff.  Each group is a one-based monster index (0 if unused)
+// followed by a maximum group size.  See
+// <https://wasteland.gamepedia.com/Map_Data_Action_Class_4>.
+const (
+	encounterGroupsOff  = 3
+	encounterGroupCount = 4
+	encounterGroupLen   = 2
+)
+
+// EncounterCfg specifies which monsters to keep and which to filter when
+// shuffling encounters.
+type EncounterCfg struct {
+	KeepWorld      bool
+	KeepPostSewers bool
+}
+
+// EncounterPlacement records the new contents of a single monster slot after
+// an encounter shuffle.
+type EncounterPlacement struct {
+	Entry *MonsterEntry // Original monster.
+	Name  decode.MonsterName
+	Data  decode.MonsterDataElem
+}
+
+// MonsterXListMap contains blacklisted and whitelisted monsters, keyed by
+// regular location.  The selectors in each list are monster indices within
+// the location's block.
+var MonsterXListMap = map[int]TransXList{}
+
+// encounterMonsters returns the indices of the monsters referenced by an
+// encoded encounter action.
+func encounterMonsters(data []byte) []int {
+	var indices []int
+	for i := 0; i < encounterGroupCount; i++ {
+		off := encounterGroupsOff + i*encounterGroupLen
+		if off >= len(data) {
+			break
+		}
+		if data[off] != 0 {
+			indices = append(indices, int(data[off])-1)
+		}
+	}
+
+	return indices
+}
+
+// assignMonsterExactLocs sets the ExactLoc field of each monster entry.  A
+// monster is assigned the exact location of the encounter tiles that
+// reference it (see tileExactLoc).  A monster that is eligible for random
+// encounters (see MapInfo.MaxMonsters), that is referenced from several exact
+// locations, or that does not appear on the map is assigned its block's
+// regular location.
+func assignMonsterExactLocs(state decode.DecodeState, locs *LocationRegistry,
+	entries []*MonsterEntry) {
+
+	type blockMonster struct {
+		zip   defs.BlockZIP
+		index int
+	}
+
+	// -1 indicates that a monster is referenced from several exact locations.
+	tileLocs := map[blockMonster]int{}
+	scanned := map[defs.BlockZIP]struct{}{}
+
+	for _, e := range entries {
+		e.ExactLoc = e.FromLoc
+
+		block := state.Blocks[e.FromBlock.GameIdx][e.FromBlock.BlockIdx]
+		if e.Index < block.MapInfo.MaxMonsters {
+			continue
+		}
+
+		if _, ok := scanned[e.FromBlock]; !ok {
+			scanned[e.FromBlock] = struct{}{}
+
+			anchors := blockAnchors(state, locs, e.FromBlock, e.FromLoc)
+			md := block.MapData
+			elems := block.ActionTables.T4.Elems
+			for y, row := range md.ActionClasses {
+				for x, class := range row {
+					sel := md.ActionSelectors[y][x]
+					if class != IDEncounter || sel >= len(elems) {
+						continue
+					}
+
+					loc := tileExactLoc(anchors, e.FromLoc,
+						gen.Point{X: x, Y: y})
+					for _, idx := range encounterMonsters(elems[sel]) {
+						bm := blockMonster{e.FromBlock, idx}
+						if cur, ok := tileLocs[bm]; ok && cur != loc {
+							tileLocs[bm] = -1
+						} else {
+							tileLocs[bm] = loc
+						}
+					}
+				}
+			}
+		}
+
+		loc, ok := tileLocs[blockMonster{e.FromBlock, e.Index}]
+		if ok && loc != -1 {
+			e.ExactLoc = loc
+		}
+	}
+}
+
+// shouldKeepMonster indicates whether a given monster should be kept
+// according to an EncounterCfg.
+func shouldKeepMonster(entry MonsterEntry, cfg EncounterCfg,
+	locs *LocationRegistry) bool {
+
+	logDiscard := func(reason string) {
+		log.Debugf("discarding monster (%s) %d \"%s\": %s",
+			LocationString(entry.ExactLoc), entry.Index,
+			digest.MonsterNameSingular(entry.Name), reason)
+	}
+
+	if !cfg.KeepWorld && entry.FromLoc == defs.LocationWorldMap {
+		logDiscard("world map")
+		return false
+	}
+
+	if !cfg.KeepPostSewers && locs.IsPostSewers(entry.ExactLoc) {
+		logDiscard("post sewers")
+		return false
+	}
+
+	xlist := MonsterXListMap[entry.FromLoc]
+	if len(xlist.White) > 0 {
+		found := false
+		for _, w := range xlist.White {
+			if entry.Index == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			logDiscard("not whitelisted")
+			return false
+		}
+	}
+	for _, b := range xlist.Black {
+		if entry.Index == b {
+			logDiscard("blacklisted")
+			return false
+		}
+	}
+
+	return true
+}
+
+// CollectEncounters gathers the monsters from among all MSQ blocks that
+// satisfy the given EncounterCfg and groups them by exact location (see
+// assignMonsterExactLocs).
+func CollectEncounters(state decode.DecodeState,
+	cfg EncounterCfg) (map[int][]*MonsterEntry, error) {
+
+	return collectEncounters(state, cfg, NewLocationRegistry())
+}
+
+func collectEncounters(state decode.DecodeState, cfg EncounterCfg,
+	locs *LocationRegistry) (map[int][]*MonsterEntry, error) {
+
+	entries, err := collectMonsters(state, locs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[int][]*MonsterEntry{}
+	for _, e := range entries {
+		if shouldKeepMonster(*e, cfg, locs) {
+			m[e.ExactLoc] = append(m[e.ExactLoc], e)
+		}
+	}
+
+	return m, nil
+}
+
+// encounterTier groups monsters that may be exchanged with one another.
+// Monsters are only exchanged between locations of equal depth, and
+// post-sewers monsters are never exchanged with pre-sewers ones.  A
+// monster's encoded name length is also part of its tier so that every
+// block's monster names section retains its size.
+type encounterTier struct {
+	Depth      int
+	PostSewers bool
+	NameLen    int
+}
+
+// ShuffleEncounters randomly redistributes monsters among the locations in
+// the given state.  LocationDepthMap serves as a difficulty proxy: a monster
+// only moves to a location with the same depth as its original one.  The
+// same seed and state always produce the same result.
+func ShuffleEncounters(state *decode.DecodeState, cfg EncounterCfg,
+	seed int64) ([]EncounterPlacement, error) {
+
+	reg := NewLocationRegistry()
+
+	m, err := collectEncounters(*state, cfg, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	var locs []int
+	for loc, _ := range m {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+
+	tiers := map[encounterTier][]*MonsterEntry{}
+	var tierKeys []encounterTier
+	for _, loc := range locs {
+		depth, err := reg.Depth(loc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range m[loc] {
+			tier := encounterTier{
+				Depth:      depth,
+				PostSewers: reg.IsPostSewers(loc),
+				NameLen:    len(decode.EncodeMonsterName(e.Name)),
+			}
+			if tiers[tier] == nil {
+				tierKeys = append(tierKeys, tier)
+			}
+			tiers[tier] = append(tiers[tier], e)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	var placements []EncounterPlacement
+	for _, tier := range tierKeys {
+		entries := tiers[tier]
+
+		perm := rng.Perm(len(entries))
+		for i, e := range entries {
+			src := entries[perm[i]]
+			placements = append(placements, EncounterPlacement{
+				Entry: e,
+				Name:  src.Name,
+				Data:  src.Data,
+			})
+		}
+	}
+
+	for _, p := range placements {
+		if err := SetMonster(state, p.Entry.FromBlock, p.Entry.Index,
+			p.Name, p.Data); err != nil {
+
+			return nil, err
+		}
+	}
+
+	return placements, nil
+}
+
+// EncounterSpoiler produces a human readable description of an encounter
+// shuffle.
+func EncounterSpoiler(placements []EncounterPlacement) string {
+	var lines []string
+	for _, p := range placements {
+		lines = append(lines, fmt.Sprintf("%-36s %2d: %-20s --> %s",
+			LocationDisplayName(p.Entry.ExactLoc), p.Entry.Index,
+			digest.MonsterNameSingular(p.Entry.Name),
+			digest.MonsterNameSingular(p.Name)))
+	}
+
+	return strings.Join(lines, "\n")
+}
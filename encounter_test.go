@@ -0,0 +1,70 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestAssignMonsterExactLocsPoliceStation(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	// The Bishop's office exit is at (2,2), the garage exit is at (20,20),
+	// and the police station room's exit is at (20,2).
+	state := policeStationState()
+	setTile(state, 0, defs.Block0PoliceStation, 20, 2, action.IDTransition, 3)
+	setTrans(state, 0, defs.Block0PoliceStation, 3, action.Transition{
+		LocX:     9,
+		LocY:     8,
+		Location: defs.LocationNeedles,
+	})
+
+	block := &state.Blocks[0][defs.Block0PoliceStation]
+
+	// Monster 0 is eligible for random encounters.
+	block.MapInfo.MaxMonsters = 1
+
+	// encounter creates an encounter action with a single group containing
+	// the specified monster.
+	encounter := func(index int) []byte {
+		return []byte{0, 0, 0, byte(index + 1), 1}
+	}
+	block.ActionTables.T4.Elems = [][]byte{
+		encounter(0),
+		encounter(1),
+		encounter(2),
+		encounter(3),
+	}
+
+	setTile(state, 0, defs.Block0PoliceStation, 3, 20, IDEncounter, 0)
+	setTile(state, 0, defs.Block0PoliceStation, 3, 3, IDEncounter, 1)
+	setTile(state, 0, defs.Block0PoliceStation, 19, 19, IDEncounter, 2)
+	setTile(state, 0, defs.Block0PoliceStation, 18, 3, IDEncounter, 2)
+	setTile(state, 0, defs.Block0PoliceStation, 18, 4, IDEncounter, 3)
+
+	var entries []*MonsterEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, &MonsterEntry{
+			FromBlock: defs.BlockZIP{0, defs.Block0PoliceStation},
+			FromLoc:   defs.LocationPoliceStation,
+			Index:     i,
+		})
+	}
+
+	assignMonsterExactLocs(state, NewLocationRegistry(), entries)
+
+	wants := []int{
+		defs.LocationPoliceStation,      // Random encounter.
+		SubLocationNeedlesBishopsOffice, // Near (2,2).
+		defs.LocationPoliceStation,      // Garage and police station room.
+		SubLocationNeedlesPoliceStation, // Near (20,2).
+		defs.LocationPoliceStation,      // Not on the map.
+	}
+	for i, want := range wants {
+		if have := entries[i].ExactLoc; have != want {
+			t.Errorf("monster %d: have=%s want=%s", i,
+				LocationString(have), LocationString(want))
+		}
+	}
+}
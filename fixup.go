@@ -0,0 +1,445 @@
+package wlmanip
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Fixup is a named modification that makes a game compatible with transition
+// replacement.  Fixups are applied by FixupTransitions.
+type Fixup struct {
+	Name        string
+	Description string
+	Enabled     bool
+
+	// Selectors lists the transitions that the fixup modifies.
+	Selectors []SubLocDesc
+
+	// Check returns nil if the state is in the vanilla condition that the
+	// fixup expects.
+	Check func(state decode.DecodeState) error
+
+	// Applied indicates whether the fixup has already been applied to the
+	// state.
+	Applied func(state decode.DecodeState) bool
+
+	Apply  func(state *decode.DecodeState) error
+	Revert func(state *decode.DecodeState) error
+}
+
+// FixupStatus describes the condition of a game with respect to a single
+// fixup.
+type FixupStatus int
+
+const (
+	FixupStatusVanilla FixupStatus = iota
+	FixupStatusApplied
+	FixupStatusUnexpected
+)
+
+var fixupStatusNameMap = map[FixupStatus]string{
+	FixupStatusVanilla:    "vanilla",
+	FixupStatusApplied:    "applied",
+	FixupStatusUnexpected: "unexpected",
+}
+
+func (s FixupStatus) String() string {
+	return fixupStatusNameMap[s]
+}
+
+// FixupReport describes the condition of a game with respect to a single
+// fixup.
+type FixupReport struct {
+	Name        string
+	Description string
+	Enabled     bool
+	Status      FixupStatus
+	Err         error // Set if the status is "unexpected".
+}
+
+// TransPatch describes a modification to a single transition.  It is the
+// building block of a transition fixup (see NewTransFixup).
+type TransPatch struct {
+	Desc SubLocDesc
+
+	// IsVanilla indicates whether the transition is in its expected vanilla
+	// state.
+	IsVanilla func(t action.Transition) bool
+
+	// IsPatched indicates whether the patch has already been applied.
+	IsPatched func(t action.Transition) bool
+
+	// Patch modifies the transition.
+	Patch func(t *action.Transition)
+
+	// Unpatch restores the vanilla value of a patched transition.  The
+	// vanilla value comes from the patch definition itself, never from the
+	// state that was patched or from captured tables.
+	Unpatch func(t *action.Transition) error
+}
+
+// NewTransFixup creates a fixup that modifies a set of transitions.  Revert
+// restores each transition with its patch's Unpatch function, so it works on
+// any patched state, including one that was patched in an earlier run.
+func NewTransFixup(name string, description string,
+	patches ...TransPatch) *Fixup {
+
+	var descs []SubLocDesc
+	for _, p := range patches {
+		descs = append(descs, p.Desc)
+	}
+
+	f := &Fixup{
+		Name:        name,
+		Description: description,
+		Enabled:     true,
+		Selectors:   descs,
+	}
+
+	lookup := func(state decode.DecodeState,
+		p TransPatch) (*action.Transition, error) {
+
+		t := transitionAt(state, p.Desc)
+		if t == nil {
+			return nil, wlerr.Errorf("fixup %s: no transition: %s",
+				name, SubLocDescString(p.Desc))
+		}
+
+		return t, nil
+	}
+
+	f.Check = func(state decode.DecodeState) error {
+		for _, p := range patches {
+			t, err := lookup(state, p)
+			if err != nil {
+				return err
+			}
+			if !p.IsVanilla(*t) {
+				return wlerr.Errorf(
+					"fixup %s: transition not in vanilla state: %s %+v",
+					name, SubLocDescString(p.Desc), *t)
+			}
+		}
+
+		return nil
+	}
+
+	f.Applied = func(state decode.DecodeState) bool {
+		for _, p := range patches {
+			t := transitionAt(state, p.Desc)
+			if t == nil || !p.IsPatched(*t) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	f.Apply = func(state *decode.DecodeState) error {
+		if err := f.Check(*state); err != nil {
+			return err
+		}
+
+		for _, p := range patches {
+			t, _ := lookup(*state, p)
+			oldT := *t
+
+			p.Patch(t)
+
+			log.Debugf("fixup %s: %s: %+v --> %+v",
+				name, SubLocDescString(p.Desc), oldT, *t)
+		}
+
+		return nil
+	}
+
+	f.Revert = func(state *decode.DecodeState) error {
+		// Compute every reverted value before modifying the state.
+		reverted := make([]action.Transition, len(patches))
+		for i, p := range patches {
+			t, err := lookup(*state, p)
+			if err != nil {
+				return err
+			}
+			if !p.IsPatched(*t) {
+				return wlerr.Errorf(
+					"fixup %s: cannot revert: transition not patched: %s",
+					name, SubLocDescString(p.Desc))
+			}
+
+			if p.Unpatch == nil {
+				return wlerr.Errorf(
+					"fixup %s: cannot revert: patch is irreversible: %s",
+					name, SubLocDescString(p.Desc))
+			}
+
+			reverted[i] = *t
+			if err := p.Unpatch(&reverted[i]); err != nil {
+				return wlerr.Wrapf(err, "fixup %s: cannot revert: %s",
+					name, SubLocDescString(p.Desc))
+			}
+		}
+
+		for i, p := range patches {
+			t, _ := lookup(*state, p)
+			*t = reverted[i]
+		}
+
+		return nil
+	}
+
+	return f
+}
+
+// relToAbsPatch produces a patch that converts a relative transition to an
+// absolute one.  rel is the transition's vanilla relative offset, which
+// unpatching restores; the destination location is not modified.  If rel is
+// nil, the vanilla offset has not been recorded, any relative transition is
+// considered vanilla, and the patch cannot be reverted.
+func relToAbsPatch(gameIdx int, blockIdx int, selector int, rel *gen.Point,
+	coords gen.Point) TransPatch {
+
+	return TransPatch{
+		Desc: SubLocDesc{
+			GameIdx:  gameIdx,
+			BlockIdx: blockIdx,
+			Selector: selector,
+		},
+		IsVanilla: func(t action.Transition) bool {
+			return t.Relative &&
+				(rel == nil || t.LocX == rel.X && t.LocY == rel.Y)
+		},
+		IsPatched: func(t action.Transition) bool {
+			return !t.Relative && t.LocX == coords.X && t.LocY == coords.Y
+		},
+		Patch: func(t *action.Transition) {
+			t.MakeAbsolute(coords)
+		},
+		Unpatch: func(t *action.Transition) error {
+			if rel == nil {
+				return wlerr.Errorf(
+					"vanilla relative offset not recorded in patch definition")
+			}
+
+			t.Relative = true
+			t.LocX = rel.X
+			t.LocY = rel.Y
+			return nil
+		},
+	}
+}
+
+// fixups is the registry of fixups, in the order they get applied.
+var fixups = []*Fixup{
+	// The vanilla relative offsets of the following three transitions
+	// have not been recorded yet, so these fixups cannot be reverted.
+	NewTransFixup("needles-downtown-east",
+		"Make the Needles --> Downtown East transition absolute",
+		relToAbsPatch(0, defs.Block0Needles, 11, nil,
+			gen.Point{X: 30, Y: 13})),
+
+	NewTransFixup("needles-downtown-west",
+		"Make the Needles --> Downtown West transition absolute",
+		relToAbsPatch(0, defs.Block0Needles, 20, nil,
+			gen.Point{X: 1, Y: 14})),
+
+	NewTransFixup("downtown-west-needles",
+		"Make the Downtown West --> Needles transition absolute",
+		relToAbsPatch(0, defs.Block0NeedlesDowntownWest, 2, nil,
+			gen.Point{X: 35, Y: 29})),
+
+	// The exit from the proton ax room uses a "previous" transition.  The
+	// previous location is incompatible with transition replacement, so change
+	// it to explicitly specify the coordinates outside the building in Las
+	// Vegas.
+	NewTransFixup("proton-ax-room-exit",
+		"Make the proton ax room exit lead explicitly to Las Vegas",
+		TransPatch{
+			Desc: SubLocDesc{
+				GameIdx:  1,
+				BlockIdx: defs.Block1FatFreddys,
+				Selector: 5,
+			},
+			IsVanilla: func(t action.Transition) bool {
+				return t.Location == defs.LocationPrevious
+			},
+			IsPatched: func(t action.Transition) bool {
+				return t.Location == defs.LocationLasVegas &&
+					t.LocX == 46 && t.LocY == 12
+			},
+			Patch: func(t *action.Transition) {
+				t.Location = defs.LocationLasVegas
+				t.LocX = 46
+				t.LocY = 12
+			},
+			// The coordinates of a "previous" transition are not used, so
+			// only the location needs to be restored.
+			Unpatch: func(t *action.Transition) error {
+				t.Location = defs.LocationPrevious
+				return nil
+			},
+		}),
+}
+
+// RegisterFixup adds a fixup to the end of the registry.
+func RegisterFixup(f *Fixup) error {
+	if f.Name == "" {
+		return wlerr.Errorf("failed to register fixup: empty name")
+	}
+	if f.Check == nil || f.Applied == nil || f.Apply == nil || f.Revert == nil {
+		return wlerr.Errorf("failed to register fixup %s: missing function",
+			f.Name)
+	}
+	if _, err := LookupFixup(f.Name); err == nil {
+		return wlerr.Errorf("failed to register fixup %s: duplicate name",
+			f.Name)
+	}
+
+	fixups = append(fixups, f)
+	return nil
+}
+
+// Fixups returns the registered fixups in the order they get applied.
+func Fixups() []*Fixup {
+	return append([]*Fixup{}, fixups...)
+}
+
+// LookupFixup retrieves the registered fixup with the given name.
+func LookupFixup(name string) (*Fixup, error) {
+	for _, f := range fixups {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+
+	return nil, wlerr.Errorf("no such fixup: %s", name)
+}
+
+// EnableFixup causes FixupTransitions to apply the named fixup.
+func EnableFixup(name string) error {
+	f, err := LookupFixup(name)
+	if err != nil {
+		return err
+	}
+
+	f.Enabled = true
+	return nil
+}
+
+// DisableFixup prevents FixupTransitions from applying the named fixup.
+func DisableFixup(name string) error {
+	f, err := LookupFixup(name)
+	if err != nil {
+		return err
+	}
+
+	f.Enabled = false
+	return nil
+}
+
+// ReportFixups describes the condition of the given state with respect to
+// every registered fixup.
+func ReportFixups(state decode.DecodeState) []FixupReport {
+	var reports []FixupReport
+
+	for _, f := range fixups {
+		r := FixupReport{
+			Name:        f.Name,
+			Description: f.Description,
+			Enabled:     f.Enabled,
+		}
+
+		if f.Applied(state) {
+			r.Status = FixupStatusApplied
+		} else if err := f.Check(state); err != nil {
+			r.Status = FixupStatusUnexpected
+			r.Err = err
+		} else {
+			r.Status = FixupStatusVanilla
+		}
+
+		reports = append(reports, r)
+	}
+
+	return reports
+}
+
+// String produces a user-friendly string for a fixup report.
+func (r FixupReport) String() string {
+	enabled := "disabled"
+	if r.Enabled {
+		enabled = "enabled"
+	}
+
+	s := fmt.Sprintf("%-24s %-8s %-10s %s",
+		r.Name, enabled, r.Status, r.Description)
+	if r.Err != nil {
+		s += fmt.Sprintf(" (%s)", r.Err)
+	}
+
+	return s
+}
+
+// FixupTransitions applies every enabled fixup to the given state.  The
+// fixups convert some relative transitions to absolute and apply some
+// miscellaneous changes that make transition replacement possible.  Fixups
+// that have already been applied are detected and skipped, so calling this
+// function more than once on the same state is harmless.
+func FixupTransitions(state *decode.DecodeState) error {
+	for _, f := range fixups {
+		if !f.Enabled {
+			continue
+		}
+
+		if f.Applied(*state) {
+			log.Debugf("fixup already applied: %s", f.Name)
+			continue
+		}
+
+		if err := f.Apply(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PrepareState readies a freshly decoded game for transition replacement.  It
+// detects the game's data version, installs that version's tables and fixups
+// (see DetectDataVersion), and applies every enabled fixup (see
+// FixupTransitions).  It must be called once, before the state is collected
+// and before any ops are executed against it.  Ops never reapply fixups: once
+// an op has rewritten a fixup's transitions, the fixup is considered done.
+func PrepareState(state *decode.DecodeState) error {
+	if err := selectDataVersion(*state); err != nil {
+		return err
+	}
+
+	return FixupTransitions(state)
+}
+
+// RevertFixups reverts every applied fixup in the reverse order of
+// application.  If any fixup cannot be reverted, an error is returned and the
+// state is left unmodified.
+func RevertFixups(state *decode.DecodeState) error {
+	dup := CloneDecodeState(*state)
+
+	for i := len(fixups) - 1; i >= 0; i-- {
+		f := fixups[i]
+		if f.Applied(dup) {
+			if err := f.Revert(&dup); err != nil {
+				return err
+			}
+		}
+	}
+
+	*state = dup
+	return nil
+}
@@ -0,0 +1,69 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+)
+
+func TestRelToAbsPatchRevert(t *testing.T) {
+	p := relToAbsPatch(0, defs.Block0Needles, 11, &gen.Point{X: 2, Y: -1},
+		gen.Point{X: 30, Y: 13})
+
+	orig := action.Transition{
+		Relative: true,
+		LocX:     2,
+		LocY:     -1,
+		Location: defs.LocationNeedlesDowntownEast,
+	}
+	if !p.IsVanilla(orig) {
+		t.Fatalf("vanilla transition not recognized")
+	}
+
+	tr := orig
+	p.Patch(&tr)
+	if !p.IsPatched(tr) {
+		t.Fatalf("patched transition not recognized")
+	}
+
+	if err := p.Unpatch(&tr); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if tr != orig {
+		t.Errorf("have=%+v want=%+v", tr, orig)
+	}
+}
+
+func TestRelToAbsPatchRevertUnknown(t *testing.T) {
+	p := relToAbsPatch(0, defs.Block0Needles, 11, nil,
+		gen.Point{X: 30, Y: 13})
+
+	tr := action.Transition{Location: defs.LocationNeedlesDowntownEast}
+	p.Patch(&tr)
+	if err := p.Unpatch(&tr); err == nil {
+		t.Errorf("unpatch succeeded without a recorded vanilla offset")
+	}
+}
+
+func TestRevertFixupsAtomic(t *testing.T) {
+	state := testState()
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The shipped relative-to-absolute fixups cannot be reverted, so the
+	// proton ax room fixup must not be reverted either.
+	if err := RevertFixups(&state); err == nil {
+		t.Fatalf("revert succeeded despite irreversible fixups")
+	}
+
+	desc := SubLocDesc{1, defs.Block1FatFreddys, 5}
+	if have := transitionAt(state, desc).Location; have !=
+		defs.LocationLasVegas {
+
+		t.Errorf("state modified by failed revert: %s: have=%s",
+			SubLocDescString(desc), LocationString(have))
+	}
+}
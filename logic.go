@@ -0,0 +1,193 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+)
+
+// TokenSewers is held once the player has completed the Las Vegas sewers.
+const TokenSewers = "progress:sewers"
+
+// Requirement is a set of tokens that must all be held.  A token is an
+// arbitrary string naming an item, skill, or unit of story progression (e.g.,
+// "skill:picklock", "item:onyx-ring", TokenSewers).
+type Requirement []string
+
+// TransRequirementMap contains the prerequisites of individual transitions,
+// keyed by their exact block and selector.
+var TransRequirementMap = map[SubLocDesc]Requirement{}
+
+// LocationRequirementMap contains the prerequisites for entering an exact
+// location by any route.  By default, every location in
+// LocationPostSewersMap requires TokenSewers.
+var LocationRequirementMap = defaultLocationRequirements()
+
+// LocationGrantMap lists the tokens that the player obtains upon reaching an
+// exact location.
+var LocationGrantMap = map[int][]string{
+	defs.LocationLasVegasSewersEast: []string{TokenSewers},
+}
+
+func defaultLocationRequirements() map[int]Requirement {
+	m := map[int]Requirement{}
+	for loc, post := range LocationPostSewersMap {
+		if post {
+			m[loc] = Requirement{TokenSewers}
+		}
+	}
+
+	return m
+}
+
+// LogicCfg configures a logic check.
+type LogicCfg struct {
+	// Tokens held at the start of the game (e.g., starting skills).
+	Start []string
+
+	// Locations that must be reachable for the game to be beatable.  If
+	// empty, DefaultLogicGoals is used.
+	Goals []int
+}
+
+// DefaultLogicGoals is the set of locations that must be reachable for the
+// game to be beatable.
+var DefaultLogicGoals = []int{
+	defs.LocationBaseCochiseLevel4,
+}
+
+// LogicReport is the result of a logic check.
+type LogicReport struct {
+	Beatable     bool
+	Reachable    []int    // Sorted.
+	Unreachable  []int    // Sorted; only locations with a known depth.
+	MissingGoals []int    // Sorted.
+	Tokens       []string // Sorted; tokens held at the end of the simulation.
+}
+
+func (r *LogicReport) String() string {
+	locsString := func(locs []int) string {
+		var strs []string
+		for _, loc := range locs {
+			strs = append(strs, LocationDisplayName(loc))
+		}
+		return strings.Join(strs, ", ")
+	}
+
+	return fmt.Sprintf("beatable=%v\nunreachable: %s\nmissing goals: %s\n"+
+		"tokens: %s",
+		r.Beatable, locsString(r.Unreachable), locsString(r.MissingGoals),
+		strings.Join(r.Tokens, ", "))
+}
+
+// requirementMet indicates whether every token in a requirement is held.
+func requirementMet(req Requirement, tokens map[string]struct{}) bool {
+	for _, tok := range req {
+		if _, ok := tokens[tok]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckLogic simulates progression through the given (possibly shuffled)
+// state, starting from the world map, and reports whether the goal locations
+// remain reachable.  A transition is traversable only if its requirement (see
+// TransRequirementMap) and its destination's requirement (see
+// LocationRequirementMap) are met.  Tokens are gained when locations are
+// reached (see LocationGrantMap).  The transitions are examined as they are
+// written; fixups are not applied, and the exact destination of each
+// transition is derived from its written value (see landingExactLoc).  The
+// caller's state is not modified.
+func CheckLogic(state decode.DecodeState, cfg LogicCfg) (*LogicReport, error) {
+	locs := NewLocationRegistry()
+
+	entries, err := collectWrittenTransitions(state, locs)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := map[string]struct{}{}
+	for _, tok := range cfg.Start {
+		tokens[tok] = struct{}{}
+	}
+
+	reached := map[int]struct{}{}
+	visit := func(loc int) {
+		reached[loc] = struct{}{}
+		for _, tok := range LocationGrantMap[loc] {
+			tokens[tok] = struct{}{}
+		}
+	}
+	visit(defs.LocationWorldMap)
+
+	// Keep sweeping the transition list until nothing new is reached.
+	for progress := true; progress; {
+		progress = false
+
+		for _, e := range entries {
+			if _, ok := reached[e.FromExactLoc]; !ok {
+				continue
+			}
+			if _, ok := reached[e.ToExactLoc]; ok {
+				continue
+			}
+			if e.Trans.Location == defs.LocationPrevious ||
+				e.Trans.IsDerelict() {
+				continue
+			}
+
+			if !requirementMet(TransRequirementMap[e.Desc()], tokens) ||
+				!requirementMet(LocationRequirementMap[e.ToExactLoc], tokens) {
+				continue
+			}
+
+			log.Debugf("logic: reached %s via %s",
+				LocationString(e.ToExactLoc), SubLocDescString(e.Desc()))
+			visit(e.ToExactLoc)
+			progress = true
+		}
+	}
+
+	r := &LogicReport{}
+
+	for loc, _ := range reached {
+		r.Reachable = append(r.Reachable, loc)
+	}
+	sort.Ints(r.Reachable)
+
+	for _, loc := range locs.AllLocations() {
+		if _, err := locs.Depth(loc); err != nil {
+			continue
+		}
+		if _, ok := reached[loc]; !ok {
+			r.Unreachable = append(r.Unreachable, loc)
+		}
+	}
+
+	goals := cfg.Goals
+	if len(goals) == 0 {
+		goals = DefaultLogicGoals
+	}
+	for _, loc := range goals {
+		if _, ok := reached[loc]; !ok {
+			r.MissingGoals = append(r.MissingGoals, loc)
+		}
+	}
+	sort.Ints(r.MissingGoals)
+
+	for tok, _ := range tokens {
+		r.Tokens = append(r.Tokens, tok)
+	}
+	sort.Strings(r.Tokens)
+
+	r.Beatable = len(r.MissingGoals) == 0
+
+	return r, nil
+}
@@ -0,0 +1,113 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+// setTile places an action on a block's map, allocating a 32x32 map if the
+// block has none.
+func setTile(state decode.DecodeState, gameIdx int, blockIdx int,
+	x int, y int, class int, selector int) {
+
+	md := &state.Blocks[gameIdx][blockIdx].MapData
+	if md.ActionClasses == nil {
+		for i := 0; i < 32; i++ {
+			md.ActionClasses = append(md.ActionClasses, make([]int, 32))
+			md.ActionSelectors = append(md.ActionSelectors, make([]int, 32))
+		}
+	}
+
+	md.ActionClasses[y][x] = class
+	md.ActionSelectors[y][x] = selector
+}
+
+// setTrans sets a transition in a block's transition table.
+func setTrans(state decode.DecodeState, gameIdx int, blockIdx int,
+	selector int, t action.Transition) {
+
+	ts := &state.Blocks[gameIdx][blockIdx].ActionTables.Transitions
+	for len(*ts) <= selector {
+		*ts = append(*ts, nil)
+	}
+	(*ts)[selector] = &t
+}
+
+// policeStationState produces a state in which the world map leads to
+// Needles, and Needles leads into the police station block.  The police
+// station's map places the Bishop's office exit near (2,2) and the garage
+// exit near (20,20).
+func policeStationState() decode.DecodeState {
+	state := testState()
+
+	setTrans(state, 0, defs.Block0WorldMap, 0, action.Transition{
+		LocX:     10,
+		LocY:     10,
+		Location: defs.LocationNeedles,
+	})
+
+	setTile(state, 0, defs.Block0PoliceStation, 2, 2, action.IDTransition, 6)
+	setTile(state, 0, defs.Block0PoliceStation, 20, 20, action.IDTransition, 2)
+	setTrans(state, 0, defs.Block0PoliceStation, 6, action.Transition{
+		LocX:     8,
+		LocY:     8,
+		Location: defs.LocationNeedles,
+	})
+	setTrans(state, 0, defs.Block0PoliceStation, 2, action.Transition{
+		LocX:     8,
+		LocY:     9,
+		Location: defs.LocationNeedles,
+	})
+
+	// Vanilla: Needles --> Bishop's office.
+	setTrans(state, 0, defs.Block0Needles, 8, action.Transition{
+		LocX:     2,
+		LocY:     3,
+		Location: defs.LocationPoliceStation,
+	})
+
+	return state
+}
+
+func TestCheckLogicWrittenDestination(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	contains := func(locs []int, loc int) bool {
+		for _, l := range locs {
+			if l == loc {
+				return true
+			}
+		}
+		return false
+	}
+
+	state := policeStationState()
+
+	r, err := CheckLogic(state, LogicCfg{Goals: []int{defs.LocationNeedles}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !contains(r.Reachable, SubLocationNeedlesBishopsOffice) ||
+		contains(r.Reachable, SubLocationNeedlesGarage) {
+
+		t.Errorf("vanilla: have reachable=%v", r.Reachable)
+	}
+
+	// Rewrite the Bishop's office entrance so that it lands in the garage.
+	tr := transitionAt(state, SubLocDesc{0, defs.Block0Needles, 8})
+	tr.LocX = 19
+	tr.LocY = 20
+
+	r, err = CheckLogic(state, LogicCfg{Goals: []int{defs.LocationNeedles}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if contains(r.Reachable, SubLocationNeedlesBishopsOffice) ||
+		!contains(r.Reachable, SubLocationNeedlesGarage) {
+
+		t.Errorf("shuffled: have reachable=%v", r.Reachable)
+	}
+}
@@ -0,0 +1,313 @@
+package wlmanip
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+)
+
+// LootShuffleCfg specifies which loot bags participate in a loot shuffle.
+type LootShuffleCfg struct {
+	// Shuffle post-sewers loot among post-sewers locations.  If false,
+	// post-sewers loot is left untouched.  Post-sewers loot is never moved to
+	// a location that the player explores before the sewers (and vice versa).
+	KeepPostSewers bool
+
+	// Shuffle cash amounts in addition to items.
+	ShuffleCash bool
+}
+
+// LootPlacement records the new contents of a single loot bag after a loot
+// shuffle.
+type LootPlacement struct {
+	Entry *LootEntry // Original bag.
+	Loot  action.Loot
+}
+
+// locAnchor is a map tile known to belong to a particular exact location.
+type locAnchor struct {
+	Loc   int
+	Point gen.Point
+}
+
+// tileAnchors determines the set of anchor tiles in the specified block from
+// its map alone: a transition out of a sub-location (see SubLocMap) sits
+// inside it, and all other transition tiles belong to the block's regular
+// location.  Shuffles do not modify maps, so these anchors remain valid in a
+// shuffled state.
+func tileAnchors(state decode.DecodeState, zip defs.BlockZIP,
+	blockLoc int) []locAnchor {
+
+	block := state.Blocks[zip.GameIdx][zip.BlockIdx]
+
+	var anchors []locAnchor
+
+	md := block.MapData
+	for y, row := range md.ActionClasses {
+		for x, class := range row {
+			if class != action.IDTransition {
+				continue
+			}
+
+			loc := blockLoc
+			pair := selectorToSubLocs(SubLocDesc{
+				GameIdx:  zip.GameIdx,
+				BlockIdx: zip.BlockIdx,
+				Selector: md.ActionSelectors[y][x],
+			})
+			if pair.From != -1 {
+				loc = pair.From
+			}
+
+			anchors = append(anchors, locAnchor{
+				Loc:   loc,
+				Point: gen.Point{X: x, Y: y},
+			})
+		}
+	}
+
+	return anchors
+}
+
+// blockAnchors determines the set of anchor tiles in the specified block.  In
+// addition to the tile anchors (see tileAnchors), the landing point of every
+// transition into a sub-location (see SubLocMap) is inside it.  The landing
+// points are read from the state, so they are only meaningful in an
+// unshuffled state.
+func blockAnchors(state decode.DecodeState, locs *LocationRegistry,
+	zip defs.BlockZIP, blockLoc int) []locAnchor {
+
+	anchors := tileAnchors(state, zip, blockLoc)
+
+	var descs []SubLocDesc
+	for desc, _ := range SubLocMap {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	for _, desc := range descs {
+		pair := SubLocMap[desc]
+		if !locs.IsSubLocation(pair.To) {
+			continue
+		}
+
+		t := transitionAt(state, desc)
+		if t == nil || t.Relative || t.Location != blockLoc {
+			continue
+		}
+
+		anchors = append(anchors, locAnchor{
+			Loc:   pair.To,
+			Point: gen.Point{X: t.LocX, Y: t.LocY},
+		})
+	}
+
+	return anchors
+}
+
+// tileExactLoc determines which exact location a map tile belongs to.  The
+// tile is assigned to the location of the nearest anchor by Manhattan
+// distance.  Ties favor the block's regular location, and then the lowest
+// location ID, so the result does not depend on the order of the anchors.
+func tileExactLoc(anchors []locAnchor, blockLoc int, p gen.Point) int {
+	abs := func(i int) int {
+		if i < 0 {
+			return -i
+		}
+		return i
+	}
+
+	best := blockLoc
+	bestDist := -1
+	for _, a := range anchors {
+		dist := abs(a.Point.X-p.X) + abs(a.Point.Y-p.Y)
+
+		var better bool
+		switch {
+		case bestDist == -1 || dist != bestDist:
+			better = bestDist == -1 || dist < bestDist
+		case (a.Loc == blockLoc) != (best == blockLoc):
+			better = a.Loc == blockLoc
+		default:
+			better = a.Loc < best
+		}
+
+		if better {
+			best = a.Loc
+			bestDist = dist
+		}
+	}
+
+	return best
+}
+
+// assignLootExactLocs sets the ExactLoc field of each loot entry.  A loot bag
+// that does not appear on the map (e.g., one that is only reached through
+// another action) is assigned its block's regular location.
+func assignLootExactLocs(state decode.DecodeState, locs *LocationRegistry,
+	entries []*LootEntry) {
+
+	anchorMap := map[defs.BlockZIP][]locAnchor{}
+
+	for _, e := range entries {
+		e.ExactLoc = e.FromLoc
+
+		anchors, ok := anchorMap[e.FromBlock]
+		if !ok {
+			anchors = blockAnchors(state, locs, e.FromBlock, e.FromLoc)
+			anchorMap[e.FromBlock] = anchors
+		}
+
+		md := state.Blocks[e.FromBlock.GameIdx][e.FromBlock.BlockIdx].MapData
+	Search:
+		for y, row := range md.ActionClasses {
+			for x, class := range row {
+				if class == IDLoot && md.ActionSelectors[y][x] == e.Selector {
+					e.ExactLoc = tileExactLoc(anchors, e.FromLoc,
+						gen.Point{X: x, Y: y})
+					break Search
+				}
+			}
+		}
+	}
+}
+
+// CollectLootsByLoc gathers every loot bag from among all MSQ blocks and
+// groups them by exact location.
+func CollectLootsByLoc(state decode.DecodeState) (map[int][]*LootEntry, error) {
+	entries, err := CollectLoots(state)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[int][]*LootEntry{}
+	for _, e := range entries {
+		m[e.ExactLoc] = append(m[e.ExactLoc], e)
+	}
+
+	return m, nil
+}
+
+// shuffleLootPool shuffles the contents of a set of loot bags.  Each bag
+// keeps its number of items and whether it contains cash, so every block's
+// loot table retains its original size.
+func shuffleLootPool(rng *rand.Rand, entries []*LootEntry,
+	cfg LootShuffleCfg) []LootPlacement {
+
+	var items []action.LootItem
+	var cashes []action.LootCash
+	for _, e := range entries {
+		items = append(items, e.Loot.Items...)
+		if cfg.ShuffleCash && e.Loot.Cash != nil {
+			cashes = append(cashes, *e.Loot.Cash)
+		}
+	}
+
+	rng.Shuffle(len(items), func(i int, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+	rng.Shuffle(len(cashes), func(i int, j int) {
+		cashes[i], cashes[j] = cashes[j], cashes[i]
+	})
+
+	var placements []LootPlacement
+	for _, e := range entries {
+		loot := cloneLoot(e.Loot)
+
+		n := len(loot.Items)
+		copy(loot.Items, items[:n])
+		items = items[n:]
+
+		if cfg.ShuffleCash && loot.Cash != nil {
+			cash := cashes[0]
+			loot.Cash = &cash
+			cashes = cashes[1:]
+		}
+
+		placements = append(placements, LootPlacement{
+			Entry: e,
+			Loot:  loot,
+		})
+	}
+
+	return placements
+}
+
+// ShuffleLoots randomly redistributes loot among the loot bags in the given
+// state.  The same seed and state always produce the same result.  The
+// returned placements describe the new contents of every modified bag.
+func ShuffleLoots(state *decode.DecodeState, cfg LootShuffleCfg,
+	seed int64) ([]LootPlacement, error) {
+
+	locs := NewLocationRegistry()
+
+	entries, err := collectLoots(*state, locs)
+	if err != nil {
+		return nil, err
+	}
+
+	var early []*LootEntry
+	var late []*LootEntry
+	for _, e := range entries {
+		if locs.IsPostSewers(e.ExactLoc) {
+			if cfg.KeepPostSewers {
+				late = append(late, e)
+			} else {
+				log.Debugf("not shuffling loot %s,%d: post sewers",
+					LocationString(e.ExactLoc), e.Selector)
+			}
+		} else {
+			early = append(early, e)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	placements := shuffleLootPool(rng, early, cfg)
+	placements = append(placements, shuffleLootPool(rng, late, cfg)...)
+
+	for _, p := range placements {
+		if err := SetLoot(state, p.Entry.FromBlock, p.Entry.Selector,
+			p.Loot); err != nil {
+
+			return nil, err
+		}
+	}
+
+	return placements, nil
+}
+
+// lootString produces a user-friendly string for a loot bag.
+func lootString(loot action.Loot) string {
+	var parts []string
+	for _, item := range loot.Items {
+		parts = append(parts, fmt.Sprintf("%d", item.ID))
+	}
+	if loot.Cash != nil {
+		parts = append(parts, fmt.Sprintf("$%d", loot.Cash.Amount))
+	}
+
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// LootSpoiler produces a human readable description of a loot shuffle.
+func LootSpoiler(placements []LootPlacement) string {
+	var lines []string
+	for _, p := range placements {
+		lines = append(lines, fmt.Sprintf("%-36s %3d: %s --> %s",
+			LocationDisplayName(p.Entry.ExactLoc), p.Entry.Selector,
+			lootString(p.Entry.Loot), lootString(p.Loot)))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,83 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen"
+)
+
+func TestTileExactLocPoliceStation(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	// The Bishop's office exit is at (2,2) and the garage exit is at (20,20).
+	// Add the police station room's exit at (20,2).  Needles' Bishop's office
+	// entrance lands at (2,3).
+	state := policeStationState()
+	setTile(state, 0, defs.Block0PoliceStation, 20, 2, action.IDTransition, 3)
+	setTrans(state, 0, defs.Block0PoliceStation, 3, action.Transition{
+		LocX:     9,
+		LocY:     8,
+		Location: defs.LocationNeedles,
+	})
+
+	zip := defs.BlockZIP{0, defs.Block0PoliceStation}
+	anchors := blockAnchors(state, NewLocationRegistry(), zip,
+		defs.LocationPoliceStation)
+
+	tests := []struct {
+		p    gen.Point
+		want int
+	}{
+		{gen.Point{3, 3}, SubLocationNeedlesBishopsOffice},
+		{gen.Point{2, 6}, SubLocationNeedlesBishopsOffice},
+		{gen.Point{19, 19}, SubLocationNeedlesGarage},
+		{gen.Point{18, 3}, SubLocationNeedlesPoliceStation},
+
+		// Equidistant from the police station room (20,2) and the garage
+		// (20,20): the lower location ID wins.
+		{gen.Point{20, 11}, SubLocationNeedlesGarage},
+	}
+
+	for _, test := range tests {
+		have := tileExactLoc(anchors, defs.LocationPoliceStation, test.p)
+		if have != test.want {
+			t.Errorf("%d,%d: have=%s want=%s", test.p.X, test.p.Y,
+				LocationString(have), LocationString(test.want))
+		}
+	}
+}
+
+func TestTileExactLocTies(t *testing.T) {
+	blockLoc := defs.LocationNeedles
+	anchors := []locAnchor{
+		locAnchor{SubLocationNeedlesPoliceStation, gen.Point{0, 0}},
+		locAnchor{SubLocationNeedlesGarage, gen.Point{4, 0}},
+		locAnchor{blockLoc, gen.Point{2, 2}},
+	}
+
+	check := func(anchors []locAnchor, p gen.Point, want int) {
+		for i := 0; i < len(anchors); i++ {
+			// Rotate the anchors; the result must not depend on their order.
+			rot := append(append([]locAnchor(nil), anchors[i:]...),
+				anchors[:i]...)
+			if have := tileExactLoc(rot, blockLoc, p); have != want {
+				t.Errorf("%d,%d (rotation %d): have=%s want=%s", p.X, p.Y, i,
+					LocationString(have), LocationString(want))
+			}
+		}
+	}
+
+	// All three anchors are equidistant: the block's location wins.
+	check(anchors, gen.Point{2, 0}, blockLoc)
+
+	// Only the sub-locations are equidistant: the lower ID wins.
+	check(anchors[:2], gen.Point{2, 0}, SubLocationNeedlesGarage)
+
+	// No anchors: the block's location.
+	if have := tileExactLoc(nil, blockLoc, gen.Point{2, 0}); have != blockLoc {
+		t.Errorf("no anchors: have=%s want=%s", LocationString(have),
+			LocationString(blockLoc))
+	}
+}
@@ -0,0 +1,172 @@
+package wlmanip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// CollectPreset is a named CollectCfg with a description of its effect.
+type CollectPreset struct {
+	Name        string
+	Description string
+	Cfg         CollectCfg
+}
+
+// CollectPresets lists the built-in CollectCfg presets.
+var CollectPresets = []CollectPreset{
+	CollectPreset{
+		Name: "conservative",
+		Description: "Shuffle only absolute transitions between distinct " +
+			"areas that the player explores before the sewers.  World map " +
+			"entrances, shops, and derelict buildings are left alone.",
+		Cfg: CollectCfg{},
+	},
+	CollectPreset{
+		Name: "interiors",
+		Description: "Like conservative, but also shuffle derelict " +
+			"buildings and post-sewers areas.  Towns are still entered " +
+			"from the world map as usual.",
+		Cfg: CollectCfg{
+			KeepDerelict:   true,
+			KeepPostSewers: true,
+		},
+	},
+	CollectPreset{
+		Name: "standard",
+		Description: "Like interiors, but also shuffle the transitions to " +
+			"and from the world map.",
+		Cfg: CollectCfg{
+			KeepWorld:      true,
+			KeepDerelict:   true,
+			KeepPostSewers: true,
+		},
+	},
+	CollectPreset{
+		Name: "chaos",
+		Description: "Shuffle everything that can be shuffled.  Expect " +
+			"broken games.",
+		Cfg: CollectCfg{
+			KeepWorld:          true,
+			KeepRelative:       true,
+			KeepShops:          true,
+			KeepDerelict:       true,
+			KeepPrevious:       true,
+			KeepAutoIntra:      true,
+			KeepHardcodedIntra: true,
+			KeepPostSewers:     true,
+		},
+	},
+}
+
+// LookupCollectPreset retrieves the built-in preset with the given name,
+// ignoring case.
+func LookupCollectPreset(name string) (*CollectPreset, error) {
+	for i, _ := range CollectPresets {
+		if strings.EqualFold(CollectPresets[i].Name, name) {
+			return &CollectPresets[i], nil
+		}
+	}
+
+	return nil, wlerr.Errorf("no such collect preset: %s", name)
+}
+
+// LoadCollectCfg reads a JSON-encoded CollectCfg.  Unknown fields are
+// rejected.
+func LoadCollectCfg(r io.Reader) (*CollectCfg, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	cfg := &CollectCfg{}
+	if err := dec.Decode(cfg); err != nil {
+		return nil, wlerr.Wrapf(err, "failed to load collect cfg")
+	}
+
+	return cfg, nil
+}
+
+// SaveCollectCfg writes a CollectCfg as JSON.
+func SaveCollectCfg(w io.Writer, cfg CollectCfg) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+
+	if err := enc.Encode(cfg); err != nil {
+		return wlerr.Wrapf(err, "failed to save collect cfg")
+	}
+
+	return nil
+}
+
+// previousWarnings returns a warning for each op that leaves a "previous"
+// transition pointing into a shuffled sub-location.  After an op, the player
+// arrives at B.To from A.From; if A.From is a sub-location, every "previous"
+// transition leaving B.To returns the player to A.From's block rather than to
+// the sub-location's entrance.
+func previousWarnings(coll *Collection, ops []TransOp) []string {
+	var warnings []string
+
+	for _, op := range ops {
+		if op.A.From == op.B.From || !coll.locs.IsSubLocation(op.A.From) {
+			continue
+		}
+
+		var sels []string
+		for _, e := range coll.Query(op.B.To, TransQuery{
+			Include: TransClassPrevious,
+		}) {
+			sels = append(sels, SubLocDescString(e.Desc()))
+		}
+		if len(sels) == 0 {
+			continue
+		}
+
+		warnings = append(warnings, fmt.Sprintf(
+			"KeepPrevious: op %s <-- %s: \"previous\" transitions in %s "+
+				"(%s) now point into shuffled sub-location %s",
+			lpString(op.A), lpString(op.B), LocationString(op.B.To),
+			strings.Join(sels, ", "), LocationString(op.A.From)))
+	}
+
+	return warnings
+}
+
+// ValidateCollectCfg returns a warning for each setting in a CollectCfg
+// that is known to produce broken games.  If a collection and the ops planned
+// against it are specified, combinations of settings and ops are checked
+// instead of settings alone; coll and ops may be nil.
+func ValidateCollectCfg(cfg CollectCfg, coll *Collection,
+	ops []TransOp) []string {
+
+	var warnings []string
+
+	if cfg.KeepPrevious {
+		if coll == nil {
+			warnings = append(warnings,
+				"KeepPrevious: \"previous\" transitions return the player "+
+					"to the last location visited; after a shuffle, this can "+
+					"be a shuffled sub-location that is unrelated to the "+
+					"route taken")
+		} else {
+			warnings = append(warnings, previousWarnings(coll, ops)...)
+		}
+	}
+
+	if cfg.KeepRelative {
+		warnings = append(warnings,
+			"KeepRelative: relative transitions offset the player's "+
+				"current coordinates; copied elsewhere, they put the player "+
+				"at arbitrary coordinates")
+	}
+
+	if cfg.KeepShops {
+		warnings = append(warnings,
+			"KeepShops: shop transitions trigger a shop action in their "+
+				"own block; copied elsewhere, they may lead to the wrong shop "+
+				"or to none at all")
+	}
+
+	return warnings
+}
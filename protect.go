@@ -0,0 +1,77 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// ProtectedSelectorMap contains transitions that must never be modified,
+// keyed by their exact block and selector.  The value is a description of why
+// the transition is protected.  Protected transitions are discarded by
+// Collect, and ExecTransOp fails rather than overwrite one.
+var ProtectedSelectorMap = map[SubLocDesc]string{}
+
+// ProtectSelector marks a transition as protected.
+func ProtectSelector(desc SubLocDesc, reason string) {
+	ProtectedSelectorMap[desc] = reason
+}
+
+// UnprotectSelector removes a transition's protection.
+func UnprotectSelector(desc SubLocDesc) {
+	delete(ProtectedSelectorMap, desc)
+}
+
+// SelectorIsProtected indicates whether a transition is protected.  If it is,
+// the reason for its protection is also returned.
+func SelectorIsProtected(desc SubLocDesc) (string, bool) {
+	reason, ok := ProtectedSelectorMap[desc]
+	return reason, ok
+}
+
+// SubLocDescString produces a user-friendly string for the given block and
+// selector.
+func SubLocDescString(desc SubLocDesc) string {
+	return fmt.Sprintf("game=%d block=%d selector=%d",
+		desc.GameIdx, desc.BlockIdx, desc.Selector)
+}
+
+// checkProtected returns an error if any of the given entries is protected.
+func checkProtected(entries []*TransEntry) error {
+	var descs []SubLocDesc
+	for _, e := range entries {
+		if _, ok := SelectorIsProtected(e.Desc()); ok {
+			descs = append(descs, e.Desc())
+		}
+	}
+
+	if len(descs) == 0 {
+		return nil
+	}
+
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	var strs []string
+	for _, desc := range descs {
+		strs = append(strs, fmt.Sprintf("{%s (%s)}",
+			SubLocDescString(desc), ProtectedSelectorMap[desc]))
+	}
+
+	return wlerr.Errorf("attempt to write protected transition: %s",
+		strings.Join(strs, ", "))
+}
+
+// subLocDescLess orders block selectors by game, block, and selector.
+func subLocDescLess(a SubLocDesc, b SubLocDesc) bool {
+	if a.GameIdx != b.GameIdx {
+		return a.GameIdx < b.GameIdx
+	}
+	if a.BlockIdx != b.BlockIdx {
+		return a.BlockIdx < b.BlockIdx
+	}
+	return a.Selector < b.Selector
+}
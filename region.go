@@ -0,0 +1,205 @@
+package wlmanip
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// Region is a named set of exact locations.  Shuffles can be restricted to
+// routes whose endpoints both fall within a set of regions.
+type Region struct {
+	Name        string
+	Description string
+	Locs        map[int]struct{}
+
+	// AnyEnd selects every route with at least one end in the region, rather
+	// than requiring both ends to be in the selected regions.
+	AnyEnd bool
+}
+
+// NewRegion creates a region containing the given exact locations.
+func NewRegion(name string, description string, locs ...int) *Region {
+	r := &Region{
+		Name:        name,
+		Description: description,
+		Locs:        map[int]struct{}{},
+	}
+	for _, loc := range locs {
+		r.Locs[loc] = struct{}{}
+	}
+
+	return r
+}
+
+// Contains indicates whether a region contains the given exact location.
+func (r *Region) Contains(loc int) bool {
+	_, ok := r.Locs[loc]
+	return ok
+}
+
+// customRegions contains the regions added with RegisterRegion.
+var customRegions = map[string]*Region{}
+
+// BuiltinRegions produces the set of built-in regions from the current
+// tables:
+//   - game1 / game2: every location on the respective game disk.
+//   - sublocations: every sub-location.  Every route to a sub-location leads
+//     from another location, so this region selects routes with at least one
+//     end in a sub-location (see Region.AnyEnd).
+//   - <parent>: each location that has sub-locations, along with its
+//     sub-locations and the locations containing their entrances (e.g.,
+//     "PoliceStation" also contains Needles).
+func BuiltinRegions() []*Region {
+	reg := NewLocationRegistry()
+
+	game1 := NewRegion("game1", "All locations on game disk 1")
+	game2 := NewRegion("game2", "All locations on game disk 2")
+	subs := NewRegion("sublocations",
+		"All routes into and out of sub-locations")
+	subs.AnyEnd = true
+	parentMap := map[int]*Region{}
+
+	var parentLocs []int
+
+	for _, loc := range reg.AllLocations() {
+		// Only consider locations that participate in shuffles.
+		if _, err := reg.Depth(loc); err != nil {
+			continue
+		}
+
+		if disk, err := reg.Disk(loc); err == nil {
+			switch disk {
+			case 0:
+				game1.Locs[loc] = struct{}{}
+			case 1:
+				game2.Locs[loc] = struct{}{}
+			}
+		}
+
+		if parent, ok := reg.Parent(loc); ok {
+			subs.Locs[loc] = struct{}{}
+
+			r := parentMap[parent]
+			if r == nil {
+				name := LocationString(parent)
+				r = NewRegion(name, name+" and its sub-locations", parent)
+				parentMap[parent] = r
+				parentLocs = append(parentLocs, parent)
+			}
+			r.Locs[loc] = struct{}{}
+		}
+	}
+	sort.Ints(parentLocs)
+
+	// Add the locations containing each sub-location's entrances to its
+	// parent's region.
+	for desc, pair := range SubLocMap {
+		if pair.From != -1 || !reg.IsSubLocation(pair.To) {
+			continue
+		}
+		parent, ok := reg.Parent(pair.To)
+		if !ok || parentMap[parent] == nil {
+			continue
+		}
+
+		loc, err := defs.BlockZIPToLoc(defs.BlockZIP{
+			GameIdx:  desc.GameIdx,
+			BlockIdx: desc.BlockIdx,
+		})
+		if err == nil {
+			parentMap[parent].Locs[loc] = struct{}{}
+		}
+	}
+
+	regions := []*Region{game1, game2, subs}
+	for _, loc := range parentLocs {
+		regions = append(regions, parentMap[loc])
+	}
+
+	return regions
+}
+
+// RegisterRegion adds a user-defined region.  Its name must not match that of
+// an existing region (ignoring case).
+func RegisterRegion(r *Region) error {
+	if _, err := LookupRegion(r.Name); err == nil {
+		return wlerr.Errorf("failed to register region %s: duplicate name",
+			r.Name)
+	}
+
+	customRegions[strings.ToLower(r.Name)] = r
+	return nil
+}
+
+// LookupRegion retrieves the region with the given name, ignoring case.
+func LookupRegion(name string) (*Region, error) {
+	for _, r := range BuiltinRegions() {
+		if strings.EqualFold(r.Name, name) {
+			return r, nil
+		}
+	}
+
+	if r := customRegions[strings.ToLower(name)]; r != nil {
+		return r, nil
+	}
+
+	return nil, wlerr.Errorf("no such region: %s", name)
+}
+
+// LookupRegions retrieves the regions with the given names.
+func LookupRegions(names []string) ([]*Region, error) {
+	var regions []*Region
+	for _, name := range names {
+		r, err := LookupRegion(name)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, r)
+	}
+
+	return regions, nil
+}
+
+// pairInRegions indicates whether both ends of a location pair fall within
+// the given regions.  The ends do not need to be in the same region.  A pair
+// with either end in an AnyEnd region is always selected.
+func pairInRegions(lp defs.LocPair, regions []*Region) bool {
+	for _, r := range regions {
+		if r.AnyEnd && (r.Contains(lp.From) || r.Contains(lp.To)) {
+			return true
+		}
+	}
+
+	contains := func(loc int) bool {
+		for _, r := range regions {
+			if r.Contains(loc) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return contains(lp.From) && contains(lp.To)
+}
+
+// FilteredRoundTripsIn is like FilteredRoundTrips, but it only returns pairs
+// whose ends fall within the given regions (see pairInRegions).  If no regions are
+// specified, all pairs are returned.
+func (c *Collection) FilteredRoundTripsIn(regions []*Region) []defs.LocPair {
+	pairs := c.FilteredRoundTrips()
+	if len(regions) == 0 {
+		return pairs
+	}
+
+	var in []defs.LocPair
+	for _, lp := range pairs {
+		if pairInRegions(lp, regions) {
+			in = append(in, lp)
+		}
+	}
+
+	return in
+}
@@ -0,0 +1,116 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+// subLocTestState extends testState with a round trip through every
+// sub-location in SubLocMap.  Each entrance leads to the sub-location's
+// parent block, and each exit leads back to the location containing the
+// entrances.
+func subLocTestState() decode.DecodeState {
+	state := testState()
+	locs := NewLocationRegistry()
+
+	blockLoc := func(desc SubLocDesc) int {
+		loc, _ := defs.BlockZIPToLoc(defs.BlockZIP{
+			GameIdx:  desc.GameIdx,
+			BlockIdx: desc.BlockIdx,
+		})
+		return loc
+	}
+
+	entryLocs := map[int]int{}
+	for desc, pair := range SubLocMap {
+		if pair.From == -1 {
+			entryLocs[pair.To] = blockLoc(desc)
+		}
+	}
+
+	for desc, pair := range SubLocMap {
+		if transitionAt(state, desc) != nil {
+			continue
+		}
+
+		var to int
+		if pair.From == -1 {
+			to, _ = locs.Parent(pair.To)
+		} else if pair.To != -1 {
+			to = pair.To
+		} else {
+			to = entryLocs[pair.From]
+		}
+
+		ts := &state.Blocks[desc.GameIdx][desc.BlockIdx].ActionTables.Transitions
+		for len(*ts) <= desc.Selector {
+			*ts = append(*ts, nil)
+		}
+		(*ts)[desc.Selector] = &action.Transition{
+			LocX:     desc.Selector,
+			LocY:     desc.Selector,
+			Location: to,
+		}
+	}
+
+	return state
+}
+
+func TestBuiltinRegionsSelectPairs(t *testing.T) {
+	UseDataVersion(OriginalDataVersion)
+
+	state := subLocTestState()
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	coll, err := Collect(state, CollectCfg{
+		KeepPostSewers:     true,
+		KeepHardcodedIntra: true,
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, r := range BuiltinRegions() {
+		if len(coll.FilteredRoundTripsIn([]*Region{r})) == 0 {
+			t.Errorf("region %s selects no pairs", r.Name)
+		}
+	}
+}
+
+func TestPairInRegions(t *testing.T) {
+	a := NewRegion("a", "", defs.LocationNeedles, defs.LocationPoliceStation)
+	b := NewRegion("b", "", defs.LocationDarwin)
+	any := NewRegion("any", "", SubLocationNeedlesGarage)
+	any.AnyEnd = true
+
+	tests := []struct {
+		lp      defs.LocPair
+		regions []*Region
+		want    bool
+	}{
+		{defs.LocPair{defs.LocationNeedles, defs.LocationPoliceStation},
+			[]*Region{a}, true},
+		{defs.LocPair{defs.LocationNeedles, defs.LocationDarwin},
+			[]*Region{a}, false},
+		{defs.LocPair{defs.LocationNeedles, defs.LocationDarwin},
+			[]*Region{a, b}, true},
+		{defs.LocPair{defs.LocationNeedles, SubLocationNeedlesGarage},
+			[]*Region{b}, false},
+		{defs.LocPair{defs.LocationNeedles, SubLocationNeedlesGarage},
+			[]*Region{any}, true},
+		{defs.LocPair{SubLocationNeedlesGarage, defs.LocationDarwin},
+			[]*Region{b, any}, true},
+	}
+
+	for i, test := range tests {
+		if have := pairInRegions(test.lp, test.regions); have != test.want {
+			t.Errorf("test %d: %s: have=%v want=%v", i, lpString(test.lp),
+				have, test.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package wlmanip
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// LocationRegistry provides information about every exact location (regular
+// locations and sub-locations).  It is built from SubLocationNameMap,
+// SubLocMap, LocationDepthMap, LocationPostSewersMap, and wllib's location
+// tables.
+type LocationRegistry struct {
+	parents  map[int]int   // [sub-location]parent
+	children map[int][]int // [parent]sub-locations (sorted)
+	subs     map[int]struct{}
+	locs     []int // All exact locations (sorted).
+}
+
+// NewLocationRegistry builds a location registry from the current tables.
+// Tables can change at runtime, so callers should build one registry per
+// operation, pass it down, and not retain it for longer than necessary.
+//
+// A sub-location's parent is the regular location whose block hosts it; that
+// is, the block containing its exits (see SubLocMap).  A sub-location without
+// exits falls back to the block containing its entrances.  For example, the
+// Needles police station's rooms are entered from Needles but are hosted in
+// the police station block.
+func NewLocationRegistry() *LocationRegistry {
+	r := &LocationRegistry{
+		parents:  map[int]int{},
+		children: map[int][]int{},
+		subs:     map[int]struct{}{},
+	}
+
+	var descs []SubLocDesc
+	for desc, _ := range SubLocMap {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	// Exits take precedence over entrances, so make two passes.
+	hosts := map[int]int{}
+	for _, exits := range []bool{true, false} {
+		for _, desc := range descs {
+			pair := SubLocMap[desc]
+
+			sub := pair.To
+			if exits {
+				sub = pair.From
+			} else if pair.From != -1 {
+				continue
+			}
+			if sub < SubLocationMin {
+				continue
+			}
+			if _, ok := hosts[sub]; ok {
+				continue
+			}
+
+			loc, err := defs.BlockZIPToLoc(defs.BlockZIP{
+				GameIdx:  desc.GameIdx,
+				BlockIdx: desc.BlockIdx,
+			})
+			if err == nil {
+				hosts[sub] = loc
+			}
+		}
+	}
+
+	for sub, loc := range hosts {
+		r.parents[sub] = loc
+		r.children[loc] = append(r.children[loc], sub)
+	}
+	for _, subs := range r.children {
+		sort.Ints(subs)
+	}
+
+	for loc, _ := range defs.LocationBlockZIPMap {
+		r.locs = append(r.locs, loc)
+	}
+	for loc, _ := range SubLocationNameMap {
+		r.subs[loc] = struct{}{}
+		r.locs = append(r.locs, loc)
+	}
+	sort.Ints(r.locs)
+
+	return r
+}
+
+// IsSubLocation indicates whether the given exact location is a registered
+// sub-location.
+func (r *LocationRegistry) IsSubLocation(loc int) bool {
+	if loc < SubLocationMin {
+		return false
+	}
+
+	_, ok := r.subs[loc]
+	return ok
+}
+
+// Parent retrieves the parent of a sub-location (the regular location whose
+// block hosts it).  It returns false if the location is not a sub-location or
+// if its parent is unknown.
+func (r *LocationRegistry) Parent(loc int) (int, bool) {
+	parent, ok := r.parents[loc]
+	return parent, ok
+}
+
+// Children retrieves the sub-locations of a regular location, sorted.
+func (r *LocationRegistry) Children(loc int) []int {
+	return append([]int(nil), r.children[loc]...)
+}
+
+// AllLocations returns every exact location, sorted.
+func (r *LocationRegistry) AllLocations() []int {
+	return append([]int(nil), r.locs...)
+}
+
+// Depth retrieves the depth of an exact location from LocationDepthMap.  It
+// returns an error if the location has no depth.
+func (r *LocationRegistry) Depth(loc int) (int, error) {
+	return LocationDepth(loc)
+}
+
+// IsPostSewers indicates whether the player is expected to explore the given
+// exact location only after completing the sewers.  A sub-location inherits
+// this property from its parent.
+func (r *LocationRegistry) IsPostSewers(loc int) bool {
+	if LocationPostSewersMap[loc] {
+		return true
+	}
+
+	if parent, ok := r.Parent(loc); ok {
+		return LocationPostSewersMap[parent]
+	}
+
+	return false
+}
+
+// Disk retrieves the index of the game disk containing an exact location.
+// A sub-location is on the same disk as its parent.
+func (r *LocationRegistry) Disk(loc int) (int, error) {
+	if parent, ok := r.Parent(loc); ok {
+		loc = parent
+	}
+
+	zip := defs.LocationBlockZIPMap[loc]
+	if zip == nil {
+		return 0, wlerr.Errorf("location has no disk: %s",
+			LocationFullString(loc))
+	}
+
+	return zip.GameIdx, nil
+}
+
+// SubLocationSpec describes a sub-location to add with RegisterSubLocation.
+type SubLocationSpec struct {
+	Name   string
+	Parent int // Regular location whose block hosts the sub-location.
+	Depth  int
+
+	// Display is the player-facing name and aliases (optional; see
+	// LocationDisplayMap).
+	Display LocationDisplay
+
+	// Entrances are the transitions leading into the sub-location.
+	Entrances []SubLocDesc
+
+	// Exits are the transitions leading out of the sub-location.  They sit
+	// inside the sub-location, so they must all be in the parent's block.
+	Exits []SubLocDesc
+}
+
+// nextSubLocation returns the lowest unused sub-location id that is greater
+// than every existing one.
+func nextSubLocation() int {
+	id := SubLocationMin
+	for loc, _ := range SubLocationNameMap {
+		if loc >= id {
+			id = loc + 1
+		}
+	}
+
+	return id
+}
+
+// RegisterSubLocation adds a sub-location to the tables (SubLocationNameMap,
+// SubLocMap, LocationDepthMap, and LocationDisplayMap) and returns its newly
+// allocated id.  The new sub-location is added to the active data version's
+// tables.  An error is returned if the spec conflicts with an existing
+// location or selector; in this case, the tables are left unmodified.
+func RegisterSubLocation(spec SubLocationSpec) (int, error) {
+	if spec.Name == "" {
+		return 0, wlerr.Errorf("sub-location has no name")
+	}
+	if loc, err := ParseLocationNoCase(spec.Name); err == nil {
+		return 0, wlerr.Errorf(
+			"sub-location name conflicts with existing location: %s",
+			LocationFullString(loc))
+	}
+
+	parentZIP := defs.LocationBlockZIPMap[spec.Parent]
+	if parentZIP == nil {
+		return 0, wlerr.Errorf("sub-location %s has invalid parent: %d",
+			spec.Name, spec.Parent)
+	}
+
+	if spec.Depth < 0 {
+		return 0, wlerr.Errorf("sub-location %s has invalid depth: %d",
+			spec.Name, spec.Depth)
+	}
+	if len(spec.Entrances) == 0 {
+		return 0, wlerr.Errorf("sub-location %s has no entrances", spec.Name)
+	}
+	if len(spec.Exits) == 0 {
+		return 0, wlerr.Errorf("sub-location %s has no exits", spec.Name)
+	}
+
+	seen := map[SubLocDesc]struct{}{}
+	checkDesc := func(desc SubLocDesc) error {
+		if _, ok := seen[desc]; ok {
+			return wlerr.Errorf("sub-location %s lists selector twice: %s",
+				spec.Name, SubLocDescString(desc))
+		}
+		seen[desc] = struct{}{}
+
+		if pair, ok := SubLocMap[desc]; ok {
+			return wlerr.Errorf(
+				"sub-location %s: selector %s already maps to %d->%d",
+				spec.Name, SubLocDescString(desc), pair.From, pair.To)
+		}
+
+		return nil
+	}
+
+	for _, desc := range spec.Entrances {
+		if err := checkDesc(desc); err != nil {
+			return 0, err
+		}
+	}
+	for _, desc := range spec.Exits {
+		if err := checkDesc(desc); err != nil {
+			return 0, err
+		}
+		if desc.GameIdx != parentZIP.GameIdx ||
+			desc.BlockIdx != parentZIP.BlockIdx {
+
+			return 0, wlerr.Errorf(
+				"sub-location %s: exit %s is not in parent block %s",
+				spec.Name, SubLocDescString(desc),
+				LocationFullString(spec.Parent))
+		}
+	}
+
+	id := nextSubLocation()
+
+	SubLocationNameMap[id] = spec.Name
+	LocationDepthMap[id] = spec.Depth
+	if spec.Display.Name != "" || len(spec.Display.Aliases) > 0 {
+		LocationDisplayMap[id] = spec.Display
+	}
+	for _, desc := range spec.Entrances {
+		SubLocMap[desc] = defs.LocPair{-1, id}
+	}
+	for _, desc := range spec.Exits {
+		SubLocMap[desc] = defs.LocPair{id, -1}
+	}
+
+	log.Debugf("registered sub-location: %s", LocationFullString(id))
+
+	return id, nil
+}
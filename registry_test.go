@@ -0,0 +1,45 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestLocationRegistryParent(t *testing.T) {
+	locs := NewLocationRegistry()
+
+	tests := []struct {
+		sub    int
+		parent int
+	}{
+		{SubLocationNeedlesBishopsOffice, defs.LocationPoliceStation},
+		{SubLocationNeedlesPoliceStation, defs.LocationPoliceStation},
+		{SubLocationNeedlesGarage, defs.LocationPoliceStation},
+		{SubLocationNeedlesAmmoBunker, defs.LocationWastePit},
+		{SubLocationLasVegasJail, defs.LocationFatFreddys},
+		{SubLocationDarwinLab, defs.LocationDarwin},
+	}
+
+	for _, test := range tests {
+		parent, ok := locs.Parent(test.sub)
+		if !ok || parent != test.parent {
+			t.Errorf("%s: have=%s,%v want=%s", LocationString(test.sub),
+				LocationString(parent), ok, LocationString(test.parent))
+		}
+	}
+}
+
+func TestLocationRegistryIsSubLocation(t *testing.T) {
+	locs := NewLocationRegistry()
+
+	if !locs.IsSubLocation(SubLocationNeedlesGarage) {
+		t.Errorf("registered sub-location not recognized")
+	}
+	if locs.IsSubLocation(defs.LocationNeedles) {
+		t.Errorf("regular location reported as a sub-location")
+	}
+	if locs.IsSubLocation(nextSubLocation()) {
+		t.Errorf("unregistered sub-location reported as a sub-location")
+	}
+}
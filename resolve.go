@@ -0,0 +1,209 @@
+package wlmanip
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// MaxLocationSuggestions is the number of candidates listed when a location
+// string cannot be resolved.
+const MaxLocationSuggestions = 5
+
+// normalizeLocationName converts a location name to a canonical form for
+// comparison: lowercase, with spaces, underscores, hyphens, dashes,
+// apostrophes, periods, and parentheses removed.
+func normalizeLocationName(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		switch c {
+		case ' ', '\t', '_', '-', '—', '\'', '.', '(', ')':
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// locationCandidate is a name that ResolveLocation can match.
+type locationCandidate struct {
+	Name string // Original (unnormalized) name.
+	Norm string
+	Loc  int
+}
+
+// locationCandidates gathers every name that ResolveLocation accepts: wllib's
+// location names, sub-location names, and display names and aliases (see
+// LocationDisplayMap).  The result is sorted by normalized name.
+func locationCandidates() []locationCandidate {
+	var cands []locationCandidate
+	add := func(name string, loc int) {
+		cands = append(cands, locationCandidate{
+			Name: name,
+			Norm: normalizeLocationName(name),
+			Loc:  loc,
+		})
+	}
+
+	for loc, name := range defs.LocationNameMap {
+		add(name, loc)
+	}
+	for loc, name := range SubLocationNameMap {
+		add(name, loc)
+	}
+	for loc, d := range LocationDisplayMap {
+		if d.Name != "" {
+			add(d.Name, loc)
+		}
+		for _, alias := range d.Aliases {
+			add(alias, loc)
+		}
+	}
+
+	sort.Slice(cands, func(i int, j int) bool {
+		if cands[i].Norm != cands[j].Norm {
+			return cands[i].Norm < cands[j].Norm
+		}
+		return cands[i].Loc < cands[j].Loc
+	})
+
+	return cands
+}
+
+// editDistance computes the Levenshtein distance between two strings.
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// locationNames converts a list of exact locations to a comma-separated list
+// of names.
+func locationNames(locs []int) string {
+	var names []string
+	for _, loc := range locs {
+		names = append(names, LocationString(loc))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// suggestLocations returns the exact locations whose names are closest to the
+// given normalized string, closest first.
+func suggestLocations(norm string, cands []locationCandidate) []int {
+	best := map[int]int{}
+	for _, c := range cands {
+		d := editDistance(norm, c.Norm)
+		if prev, ok := best[c.Loc]; !ok || d < prev {
+			best[c.Loc] = d
+		}
+	}
+
+	var locs []int
+	for loc, _ := range best {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i int, j int) bool {
+		if best[locs[i]] != best[locs[j]] {
+			return best[locs[i]] < best[locs[j]]
+		}
+		return locs[i] < locs[j]
+	})
+
+	if len(locs) > MaxLocationSuggestions {
+		locs = locs[:MaxLocationSuggestions]
+	}
+
+	return locs
+}
+
+// ResolveLocation converts a user-supplied string to an exact location code.
+// It is more forgiving than ParseLocation.  It accepts:
+//   - numeric location codes (e.g., "262"),
+//   - names, display names, and aliases (see LocationDisplayMap), ignoring
+//     case, spaces, underscores, hyphens, apostrophes, periods, and
+//     parentheses (e.g., "needles bishops-office"), and
+//   - unambiguous prefixes of names and aliases (e.g., "finster" resolves to
+//     FinstersBrain, but "basecochise" is ambiguous).
+//
+// If the string cannot be resolved, the returned error lists the closest
+// candidates.
+func ResolveLocation(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, wlerr.Errorf("empty location string")
+	}
+
+	if loc, err := strconv.Atoi(s); err == nil {
+		if defs.LocationNameMap[loc] == "" && SubLocationNameMap[loc] == "" {
+			return 0, wlerr.Errorf("invalid location code: %d", loc)
+		}
+		return loc, nil
+	}
+
+	norm := normalizeLocationName(s)
+	cands := locationCandidates()
+
+	for _, c := range cands {
+		if c.Norm == norm {
+			return c.Loc, nil
+		}
+	}
+
+	prefixed := map[int]struct{}{}
+	var prefixLocs []int
+	for _, c := range cands {
+		if strings.HasPrefix(c.Norm, norm) {
+			if _, ok := prefixed[c.Loc]; !ok {
+				prefixed[c.Loc] = struct{}{}
+				prefixLocs = append(prefixLocs, c.Loc)
+			}
+		}
+	}
+	sort.Ints(prefixLocs)
+
+	switch len(prefixLocs) {
+	case 0:
+		return 0, wlerr.Errorf(
+			"invalid location string: %s (did you mean: %s?)",
+			s, locationNames(suggestLocations(norm, cands)))
+
+	case 1:
+		return prefixLocs[0], nil
+
+	default:
+		return 0, wlerr.Errorf(
+			"ambiguous location string: %s (matches: %s)",
+			s, locationNames(prefixLocs))
+	}
+}
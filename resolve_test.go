@@ -0,0 +1,55 @@
+package wlmanip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestResolveLocation(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"Needles", defs.LocationNeedles},
+		{"NEEDLES", defs.LocationNeedles},
+		{"NeedlesBishopsOffice", SubLocationNeedlesBishopsOffice},
+		{"needles_bishops-office", SubLocationNeedlesBishopsOffice},
+		{"Bishop's Office (Needles)", SubLocationNeedlesBishopsOffice},
+		{"bishop", SubLocationNeedlesBishopsOffice},
+		{"finster", defs.LocationFinstersBrain},
+		{"262", SubLocationNeedlesBishopsOffice},
+		{" 26 ", defs.LocationNeedles},
+	}
+
+	for _, test := range tests {
+		have, err := ResolveLocation(test.s)
+		if err != nil {
+			t.Errorf("\"%s\": %v", test.s, err)
+			continue
+		}
+		if have != test.want {
+			t.Errorf("\"%s\": have=%s want=%s", test.s,
+				LocationString(have), LocationString(test.want))
+		}
+	}
+}
+
+func TestResolveLocationErrors(t *testing.T) {
+	for _, s := range []string{"", "basecochise", "99999"} {
+		if loc, err := ResolveLocation(s); err == nil {
+			t.Errorf("\"%s\": resolved to %s", s, LocationString(loc))
+		}
+	}
+
+	// A misspelling must suggest the intended location.
+	_, err := ResolveLocation("Needels")
+	if err == nil {
+		t.Fatalf("resolved a misspelled location")
+	}
+	if !strings.Contains(err.Error(), LocationString(defs.LocationNeedles)) {
+		t.Errorf("suggestions do not include %s: %v",
+			LocationString(defs.LocationNeedles), err)
+	}
+}
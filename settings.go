@@ -0,0 +1,185 @@
+package wlmanip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// SettingsVersion is the format version of encoded settings strings.
+const SettingsVersion = 1
+
+const settingsChecksumLen = 4
+
+// Settings is the full set of options that determine a shuffle.  A Settings
+// value can be encoded to a short string so that players can reproduce a
+// shuffle.  Plando specifications are not part of the encoding.
+type Settings struct {
+	Collect CollectCfg
+	Shuffle ShuffleCfg
+}
+
+// collectCfgFlags lists the CollectCfg fields in encoding order.  New fields
+// must be appended.
+func collectCfgFlags(cfg *CollectCfg) []*bool {
+	return []*bool{
+		&cfg.KeepWorld,
+		&cfg.KeepRelative,
+		&cfg.KeepShops,
+		&cfg.KeepDerelict,
+		&cfg.KeepPrevious,
+		&cfg.KeepAutoIntra,
+		&cfg.KeepHardcodedIntra,
+		&cfg.KeepPostSewers,
+	}
+}
+
+// shuffleCfgFlags lists the boolean ShuffleCfg fields in encoding order.  New
+// fields must be appended.
+func shuffleCfgFlags(cfg *ShuffleCfg) []*bool {
+	return []*bool{
+		&cfg.Decoupled,
+	}
+}
+
+// settingsFlagBits is the number of flags that fit in an encoded flag byte.
+const settingsFlagBits = 8
+
+// Each flag list is packed into a single byte.  Adding a ninth flag to either
+// list requires a new settings version with a wider encoding.
+func init() {
+	if n := len(collectCfgFlags(&CollectCfg{})); n > settingsFlagBits {
+		panic(fmt.Sprintf("too many CollectCfg flags: have=%d want<=%d",
+			n, settingsFlagBits))
+	}
+	if n := len(shuffleCfgFlags(&ShuffleCfg{})); n > settingsFlagBits {
+		panic(fmt.Sprintf("too many ShuffleCfg flags: have=%d want<=%d",
+			n, settingsFlagBits))
+	}
+}
+
+func encodeFlags(flags []*bool) byte {
+	var b byte
+	for i, f := range flags {
+		if *f {
+			b |= 1 << uint(i)
+		}
+	}
+
+	return b
+}
+
+func decodeFlags(b byte, flags []*bool) {
+	for i, f := range flags {
+		*f = b&(1<<uint(i)) != 0
+	}
+}
+
+// EncodeSettings packs a set of settings into a short shareable string.  The
+// string contains a version byte and a checksum.
+func EncodeSettings(s Settings) (string, error) {
+	buf := &bytes.Buffer{}
+
+	buf.WriteByte(SettingsVersion)
+	buf.WriteByte(encodeFlags(collectCfgFlags(&s.Collect)))
+	buf.WriteByte(encodeFlags(shuffleCfgFlags(&s.Shuffle)))
+
+	if s.Shuffle.MaxAttempts < 0 || s.Shuffle.MaxAttempts > 0xffff {
+		return "", wlerr.Errorf(
+			"failed to encode settings: invalid max attempts: have=%d "+
+				"want=0-65535", s.Shuffle.MaxAttempts)
+	}
+	binary.Write(buf, binary.BigEndian, uint16(s.Shuffle.MaxAttempts))
+	binary.Write(buf, binary.BigEndian, s.Shuffle.Seed)
+
+	if len(s.Shuffle.Regions) > 0xff {
+		return "", wlerr.Errorf(
+			"failed to encode settings: too many regions: have=%d want<=255",
+			len(s.Shuffle.Regions))
+	}
+	buf.WriteByte(byte(len(s.Shuffle.Regions)))
+	for _, name := range s.Shuffle.Regions {
+		if len(name) == 0 || len(name) > 0xff {
+			return "", wlerr.Errorf(
+				"failed to encode settings: invalid region name: \"%s\"",
+				name)
+		}
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+	}
+
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeSettings unpacks a settings string produced by EncodeSettings.
+func DecodeSettings(code string) (*Settings, error) {
+	wrapErr := wlerr.MakeWrapper("failed to decode settings")
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(code))
+	if err != nil {
+		return nil, wrapErr(err, "invalid encoding")
+	}
+
+	if len(data) < 1+settingsChecksumLen {
+		return nil, wrapErr(nil, "too short: have=%d want>=%d",
+			len(data), 1+settingsChecksumLen)
+	}
+
+	body := data[:len(data)-settingsChecksumLen]
+	csum := binary.BigEndian.Uint32(data[len(data)-settingsChecksumLen:])
+	if crc32.ChecksumIEEE(body) != csum {
+		return nil, wrapErr(nil, "checksum mismatch")
+	}
+
+	if body[0] != SettingsVersion {
+		return nil, wrapErr(nil, "unsupported version: have=%d want=%d",
+			body[0], SettingsVersion)
+	}
+
+	r := bytes.NewReader(body[1:])
+	s := &Settings{}
+
+	var hdr struct {
+		CollectFlags byte
+		ShuffleFlags byte
+		MaxAttempts  uint16
+		Seed         int64
+		NumRegions   byte
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, wrapErr(err, "truncated header")
+	}
+
+	decodeFlags(hdr.CollectFlags, collectCfgFlags(&s.Collect))
+	decodeFlags(hdr.ShuffleFlags, shuffleCfgFlags(&s.Shuffle))
+	s.Shuffle.MaxAttempts = int(hdr.MaxAttempts)
+	s.Shuffle.Seed = hdr.Seed
+
+	for i := 0; i < int(hdr.NumRegions); i++ {
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, wrapErr(err, "truncated region list")
+		}
+
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, wrapErr(err, "truncated region name")
+		}
+
+		s.Shuffle.Regions = append(s.Shuffle.Regions, string(name))
+	}
+
+	if r.Len() != 0 {
+		return nil, wrapErr(nil, "trailing data: %d bytes", r.Len())
+	}
+
+	return s, nil
+}
@@ -0,0 +1,66 @@
+package wlmanip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSettingsRoundTrip(t *testing.T) {
+	in := Settings{
+		Collect: CollectCfg{
+			KeepWorld:      true,
+			KeepShops:      true,
+			KeepPostSewers: true,
+		},
+		Shuffle: ShuffleCfg{
+			Seed:        -1234567890123,
+			Regions:     []string{"needles", "sublocations"},
+			Decoupled:   true,
+			MaxAttempts: 500,
+		},
+	}
+
+	code, err := EncodeSettings(in)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	out, err := DecodeSettings(code)
+	if err != nil {
+		t.Fatalf("failed to decode \"%s\": %v", code, err)
+	}
+
+	if !reflect.DeepEqual(*out, in) {
+		t.Errorf("round trip mismatch: have=%+v want=%+v", *out, in)
+	}
+}
+
+func TestSettingsFlagCounts(t *testing.T) {
+	if n := len(collectCfgFlags(&CollectCfg{})); n > settingsFlagBits {
+		t.Errorf("too many CollectCfg flags: have=%d want<=%d",
+			n, settingsFlagBits)
+	}
+	if n := len(shuffleCfgFlags(&ShuffleCfg{})); n > settingsFlagBits {
+		t.Errorf("too many ShuffleCfg flags: have=%d want<=%d",
+			n, settingsFlagBits)
+	}
+}
+
+func TestDecodeSettingsCorrupt(t *testing.T) {
+	code, err := EncodeSettings(Settings{})
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	// Flip a character in the body so that the checksum fails.
+	b := []byte(code)
+	if b[2] == 'A' {
+		b[2] = 'B'
+	} else {
+		b[2] = 'A'
+	}
+
+	if _, err := DecodeSettings(string(b)); err == nil {
+		t.Errorf("decoded a corrupt settings string")
+	}
+}
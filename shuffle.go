@@ -0,0 +1,299 @@
+package wlmanip
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// PlandoPlacement forces a single transition replacement: the A route gets
+// replaced with the B route (see TransOp).
+type PlandoPlacement TransOp
+
+// PlandoSpec is a set of user-fixed placements.  A shuffle honors these
+// placements first and randomizes the remainder.
+type PlandoSpec struct {
+	Placements []PlandoPlacement
+}
+
+// ShuffleCfg configures a transition shuffle.
+type ShuffleCfg struct {
+	Seed   int64
+	Plando *PlandoSpec
+
+	// Names of the regions to restrict the shuffle to.  If empty, the
+	// shuffle is not restricted.
+	Regions []string
+
+	// Shuffle forward and reverse routes independently (see
+	// ExecTransOpDecoupled).
+	Decoupled bool
+
+	// The number of decoupled shuffles to attempt before giving up.  If 0,
+	// DefaultDecoupledAttempts is used.
+	MaxAttempts int
+}
+
+// parseLocPair parses a location pair of the form "<from>,<to>".  Locations
+// are parsed with ResolveLocation.
+func parseLocPair(s string) (defs.LocPair, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return defs.LocPair{}, wlerr.Errorf(
+			"invalid location pair: \"%s\": want \"<from>,<to>\"", s)
+	}
+
+	from, err := ResolveLocation(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return defs.LocPair{}, err
+	}
+
+	to, err := ResolveLocation(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return defs.LocPair{}, err
+	}
+
+	return defs.LocPair{From: from, To: to}, nil
+}
+
+// ParsePlandoPlacement parses a single plando placement of the form
+// "<a-from>,<a-to> = <b-from>,<b-to>".  For example, the following makes the
+// Highpool cave entrance lead to the Darwin lab:
+//
+//	Highpool,HighpoolCave = Darwin,DarwinLab
+func ParsePlandoPlacement(s string) (PlandoPlacement, error) {
+	parts := strings.Split(s, "=")
+	if len(parts) != 2 {
+		return PlandoPlacement{}, wlerr.Errorf(
+			"invalid plando placement: \"%s\": want \"<pair> = <pair>\"", s)
+	}
+
+	a, err := parseLocPair(parts[0])
+	if err != nil {
+		return PlandoPlacement{}, wlerr.Wrapf(err,
+			"invalid plando placement: \"%s\"", s)
+	}
+
+	b, err := parseLocPair(parts[1])
+	if err != nil {
+		return PlandoPlacement{}, wlerr.Wrapf(err,
+			"invalid plando placement: \"%s\"", s)
+	}
+
+	return PlandoPlacement{A: a, B: b}, nil
+}
+
+// ParsePlando parses a plando specification consisting of one placement per
+// line (see ParsePlandoPlacement).  Blank lines and lines starting with '#'
+// are ignored.
+func ParsePlando(r io.Reader) (*PlandoSpec, error) {
+	spec := &PlandoSpec{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := ParsePlandoPlacement(line)
+		if err != nil {
+			return nil, wlerr.Wrapf(err, "line %d", lineNum)
+		}
+
+		spec.Placements = append(spec.Placements, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, wlerr.Wrapf(err, "failed to read plando spec")
+	}
+
+	return spec, nil
+}
+
+// lpString produces a user-friendly string for a location pair.
+func lpString(lp defs.LocPair) string {
+	return LocationString(lp.From) + "->" + LocationString(lp.To)
+}
+
+// planPairs produces a set of ops that permutes the given round trips.  The
+// plando placements are assigned first; the remaining routes are shuffled.
+func planPairs(pairs []defs.LocPair, seed int64,
+	plando *PlandoSpec) ([]TransOp, error) {
+
+	avail := map[defs.LocPair]bool{}
+	for _, lp := range pairs {
+		avail[lp] = true
+	}
+
+	usedA := map[defs.LocPair]bool{}
+	usedB := map[defs.LocPair]bool{}
+
+	var ops []TransOp
+
+	if plando != nil {
+		for _, p := range plando.Placements {
+			for _, lp := range []defs.LocPair{p.A, p.B} {
+				if !avail[lp] {
+					return nil, wlerr.Errorf(
+						"impossible plando placement %s = %s: "+
+							"%s is not a usable round trip under the current "+
+							"CollectCfg filtering and region selection",
+						lpString(p.A), lpString(p.B), lpString(lp))
+				}
+			}
+			if usedA[p.A] {
+				return nil, wlerr.Errorf(
+					"impossible plando placement %s = %s: "+
+						"%s is already replaced", lpString(p.A), lpString(p.B),
+					lpString(p.A))
+			}
+			if usedB[p.B] {
+				return nil, wlerr.Errorf(
+					"impossible plando placement %s = %s: "+
+						"%s is already placed", lpString(p.A), lpString(p.B),
+					lpString(p.B))
+			}
+
+			usedA[p.A] = true
+			usedB[p.B] = true
+			ops = append(ops, TransOp(p))
+		}
+	}
+
+	var as []defs.LocPair
+	var bs []defs.LocPair
+	for _, lp := range pairs {
+		if !usedA[lp] {
+			as = append(as, lp)
+		}
+		if !usedB[lp] {
+			bs = append(bs, lp)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	perm := rng.Perm(len(bs))
+	for i, a := range as {
+		ops = append(ops, TransOp{
+			A: a,
+			B: bs[perm[i]],
+		})
+	}
+
+	return ops, nil
+}
+
+// shufflePairs retrieves the round trips that participate in a shuffle.
+func shufflePairs(coll *Collection, cfg ShuffleCfg) ([]defs.LocPair, error) {
+	regions, err := LookupRegions(cfg.Regions)
+	if err != nil {
+		return nil, err
+	}
+
+	return coll.FilteredRoundTripsIn(regions), nil
+}
+
+// checkPlando verifies that every plando placement resolves to a route that
+// an op can actually rewrite.  A placement whose pair is a filtered round trip
+// can still be unusable if all of its transitions are delisted; executing it
+// would silently do nothing, so it is rejected here instead.
+func checkPlando(coll *Collection, plando *PlandoSpec, decoupled bool) error {
+	if plando == nil {
+		return nil
+	}
+
+	for _, p := range plando.Placements {
+		var err error
+		if decoupled {
+			_, _, err = decoupledRoutes(coll, TransOp(p))
+		} else {
+			_, err = newTransOpCtxt(coll, nil, TransOp(p))
+		}
+		if err != nil {
+			return wlerr.Wrapf(err, "impossible plando placement %s = %s",
+				lpString(p.A), lpString(p.B))
+		}
+	}
+
+	return nil
+}
+
+// PlanShuffle produces a set of ops that randomly permutes the filtered round
+// trips in a collection.  If the configuration names any regions, only the
+// round trips within those regions are shuffled.  The same collection and
+// configuration always produce the same ops.  Decoupled shuffles cannot be
+// planned in advance; use ShuffleTransitions instead.
+func PlanShuffle(coll *Collection, cfg ShuffleCfg) ([]TransOp, error) {
+	if cfg.Decoupled {
+		return nil, wlerr.Errorf("cannot plan a decoupled shuffle")
+	}
+
+	pairs, err := shufflePairs(coll, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPlando(coll, cfg.Plando, false); err != nil {
+		return nil, err
+	}
+
+	return planPairs(pairs, cfg.Seed, cfg.Plando)
+}
+
+// ExecTransOps executes a sequence of ops.  Every op reads from the
+// collection, which describes the original state, so the ops are
+// independent of one another.  The ops are executed against a copy of the
+// state, so the state is only modified if every op succeeds.
+func ExecTransOps(coll *Collection, state *decode.DecodeState,
+	ops []TransOp) error {
+
+	dup := CloneDecodeState(*state)
+	for _, op := range ops {
+		if op.A == op.B {
+			log.Debugf("skipping identity op: %s", lpString(op.A))
+			continue
+		}
+
+		if err := ExecTransOp(coll, &dup, op); err != nil {
+			return err
+		}
+	}
+
+	*state = dup
+	return nil
+}
+
+// ShuffleTransitions plans a shuffle (see PlanShuffle) and executes it
+// against the given state.  It returns the executed ops.  A decoupled shuffle
+// is validated with ValidateDecoupled and retried with a new seed until it
+// passes; the state is only modified by a shuffle that passes.
+func ShuffleTransitions(coll *Collection, state *decode.DecodeState,
+	cfg ShuffleCfg) ([]TransOp, error) {
+
+	if cfg.Decoupled {
+		pairs, err := shufflePairs(coll, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return shuffleDecoupled(coll, state, pairs, cfg)
+	}
+
+	ops, err := PlanShuffle(coll, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ExecTransOps(coll, state, ops); err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
@@ -0,0 +1,156 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+)
+
+// shuffleTestColl builds a collection from the synthetic test state.
+func shuffleTestColl(t *testing.T) (*Collection, decode.DecodeState) {
+	UseDataVersion(OriginalDataVersion)
+
+	state := testState()
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("failed to apply fixups: %v", err)
+	}
+
+	coll, err := Collect(state, CollectCfg{KeepPostSewers: true})
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	return coll, state
+}
+
+var (
+	testPairDTE = defs.LocPair{
+		defs.LocationNeedles, defs.LocationNeedlesDowntownEast}
+	testPairDTW = defs.LocPair{
+		defs.LocationNeedles, defs.LocationNeedlesDowntownWest}
+)
+
+func TestPlanShufflePlando(t *testing.T) {
+	coll, _ := shuffleTestColl(t)
+
+	cfg := ShuffleCfg{
+		Plando: &PlandoSpec{
+			Placements: []PlandoPlacement{
+				PlandoPlacement{A: testPairDTE, B: testPairDTW},
+			},
+		},
+	}
+
+	ops, err := PlanShuffle(coll, cfg)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	found := false
+	for _, op := range ops {
+		if op.A == testPairDTE {
+			if op.B != testPairDTW {
+				t.Errorf("plando placement not honored: have=%s want=%s",
+					lpString(op.B), lpString(testPairDTW))
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("plando placement missing from ops: %+v", ops)
+	}
+}
+
+func TestPlanShufflePlandoConflict(t *testing.T) {
+	coll, _ := shuffleTestColl(t)
+
+	cfg := ShuffleCfg{
+		Plando: &PlandoSpec{
+			Placements: []PlandoPlacement{
+				PlandoPlacement{A: testPairDTE, B: testPairDTW},
+				PlandoPlacement{A: testPairDTW, B: testPairDTW},
+			},
+		},
+	}
+
+	if _, err := PlanShuffle(coll, cfg); err == nil {
+		t.Errorf("planned a shuffle that places a route twice")
+	}
+}
+
+func TestPlanShufflePlandoDelisted(t *testing.T) {
+	coll, _ := shuffleTestColl(t)
+
+	// Black list the only Needles --> Downtown East transition.  The pair is
+	// still a round trip, but no op can rewrite it.
+	orig, hadOrig := LocationXListPairMap[testPairDTE]
+	AddLocPairXList(testPairDTE, TransXListPair{
+		Write: TransXList{Black: []int{11}},
+	})
+	defer func() {
+		if hadOrig {
+			LocationXListPairMap[testPairDTE] = orig
+		} else {
+			delete(LocationXListPairMap, testPairDTE)
+		}
+	}()
+
+	cfg := ShuffleCfg{
+		Plando: &PlandoSpec{
+			Placements: []PlandoPlacement{
+				PlandoPlacement{A: testPairDTE, B: testPairDTW},
+			},
+		},
+	}
+
+	if _, err := PlanShuffle(coll, cfg); err == nil {
+		t.Errorf("planned a plando placement with a fully delisted route")
+	}
+
+	cfg.Decoupled = true
+	state := testState()
+	if _, err := ShuffleTransitions(coll, &state, cfg); err == nil {
+		t.Errorf("executed a decoupled plando placement with a fully " +
+			"delisted route")
+	}
+}
+
+func TestParsePlandoUnknownLocation(t *testing.T) {
+	p, err := ParsePlandoPlacement("Needles,DowntownEast = Needles,Nowhere")
+	if err == nil {
+		t.Errorf("parsed a placement with an unknown location: %+v", p)
+	}
+}
+
+func TestExecTransOpsProtected(t *testing.T) {
+	coll, state := shuffleTestColl(t)
+
+	desc := SubLocDesc{0, defs.Block0Needles, 11}
+	ProtectSelector(desc, "test")
+	defer UnprotectSelector(desc)
+
+	// The second op overwrites the protected transition.
+	ops := []TransOp{
+		TransOp{A: testPairDTW, B: testPairDTE},
+		TransOp{A: testPairDTE, B: testPairDTW},
+	}
+
+	if err := ExecTransOp(coll, &state, ops[1]); err == nil {
+		t.Errorf("overwrote a protected transition")
+	}
+
+	if err := ExecTransOps(coll, &state, ops); err == nil {
+		t.Errorf("overwrote a protected transition")
+	}
+
+	// The first op must not have been applied either.
+	dtw := SubLocDesc{0, defs.Block0Needles, 20}
+	if have := transitionAt(state, dtw).Location; have !=
+		defs.LocationNeedlesDowntownWest {
+
+		t.Errorf("state modified by failed ops: %s: have=%s want=%s",
+			SubLocDescString(dtw), LocationString(have),
+			LocationString(defs.LocationNeedlesDowntownWest))
+	}
+}
@@ -0,0 +1,272 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+)
+
+// TableProblem describes an inconsistency among the built-in location tables
+// (SubLocationNameMap, SubLocMap, LocationDepthMap, LocationPostSewersMap,
+// IntraTransitions, and LocationXListPairMap).
+type TableProblem struct {
+	Table string
+	Msg   string
+}
+
+func (p TableProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Table, p.Msg)
+}
+
+// isKnownLocation indicates whether the given exact location is a regular
+// location with a block or a named sub-location.
+func isKnownLocation(loc int) bool {
+	if defs.LocationBlockZIPMap[loc] != nil {
+		return true
+	}
+
+	_, ok := SubLocationNameMap[loc]
+	return ok
+}
+
+// locationSelectorBlock retrieves the block containing the transitions that
+// leave the given exact location.  For a sub-location, this is the block
+// containing its exits.
+func locationSelectorBlock(loc int) (defs.BlockZIP, bool) {
+	if zip := defs.LocationBlockZIPMap[loc]; zip != nil {
+		return *zip, true
+	}
+
+	var descs []SubLocDesc
+	for desc, pair := range SubLocMap {
+		if pair.From == loc {
+			descs = append(descs, desc)
+		}
+	}
+	if len(descs) == 0 {
+		return defs.BlockZIP{}, false
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	return defs.BlockZIP{
+		GameIdx:  descs[0].GameIdx,
+		BlockIdx: descs[0].BlockIdx,
+	}, true
+}
+
+// CheckTables verifies that the built-in location tables agree with each
+// other and with the given state.  Unlike VerifyVanilla, it does not care
+// whether the game has been modified; it only checks that every table refers
+// to locations and selectors that exist.  It returns one problem per
+// inconsistency, or nil if the tables are consistent.
+func CheckTables(state decode.DecodeState) []TableProblem {
+	var problems []TableProblem
+	addProblem := func(table string, format string, args ...interface{}) {
+		problems = append(problems, TableProblem{
+			Table: table,
+			Msg:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	//// SubLocationNameMap.
+
+	var subs []int
+	for loc, _ := range SubLocationNameMap {
+		subs = append(subs, loc)
+	}
+	sort.Ints(subs)
+
+	entrances := map[int]int{}
+	exits := map[int]int{}
+	for _, pair := range SubLocMap {
+		entrances[pair.To]++
+		exits[pair.From]++
+	}
+
+	names := map[string]int{}
+	for _, loc := range subs {
+		name := SubLocationNameMap[loc]
+		if loc < SubLocationMin {
+			addProblem("SubLocationNameMap",
+				"sub-location %d (%s) is less than SubLocationMin", loc, name)
+		}
+
+		if name == "" {
+			addProblem("SubLocationNameMap", "sub-location %d has no name", loc)
+		} else {
+			if other, ok := names[strings.ToLower(name)]; ok {
+				addProblem("SubLocationNameMap",
+					"sub-locations %d and %d have the same name: %s",
+					other, loc, name)
+			}
+			names[strings.ToLower(name)] = loc
+
+			if dup, err := defs.ParseLocationNoCase(name); err == nil {
+				addProblem("SubLocationNameMap",
+					"sub-location %d has the same name as location %s",
+					loc, LocationFullString(dup))
+			}
+		}
+
+		if _, ok := LocationDepthMap[loc]; !ok {
+			addProblem("LocationDepthMap", "sub-location %s has no depth",
+				LocationFullString(loc))
+		}
+		if entrances[loc] == 0 {
+			addProblem("SubLocMap", "sub-location %s has no entrances",
+				LocationFullString(loc))
+		}
+		if exits[loc] == 0 {
+			addProblem("SubLocMap", "sub-location %s has no exits",
+				LocationFullString(loc))
+		}
+	}
+
+	//// SubLocMap.
+
+	var descs []SubLocDesc
+	for desc, _ := range SubLocMap {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	for _, desc := range descs {
+		pair := SubLocMap[desc]
+		descStr := SubLocDescString(desc)
+
+		if transitionAt(state, desc) == nil {
+			addProblem("SubLocMap", "%s: no such transition", descStr)
+		}
+
+		for _, loc := range []int{pair.From, pair.To} {
+			if loc != -1 && !isKnownLocation(loc) {
+				addProblem("SubLocMap", "%s: unknown location: %d",
+					descStr, loc)
+			}
+		}
+		if pair.From < SubLocationMin && pair.To < SubLocationMin {
+			addProblem("SubLocMap",
+				"%s: neither end is a sub-location: %d->%d",
+				descStr, pair.From, pair.To)
+		}
+	}
+
+	//// LocationDepthMap.
+
+	var depthLocs []int
+	for loc, _ := range LocationDepthMap {
+		depthLocs = append(depthLocs, loc)
+	}
+	sort.Ints(depthLocs)
+
+	for _, loc := range depthLocs {
+		depth := LocationDepthMap[loc]
+		if !isKnownLocation(loc) {
+			addProblem("LocationDepthMap", "unknown location: %d", loc)
+		}
+		if depth < 0 {
+			addProblem("LocationDepthMap", "%s has negative depth: %d",
+				LocationFullString(loc), depth)
+		}
+		if depth == 0 && loc != defs.LocationWorldMap {
+			addProblem("LocationDepthMap", "%s has a depth of 0",
+				LocationFullString(loc))
+		}
+	}
+
+	//// LocationPostSewersMap.
+
+	var psLocs []int
+	for loc, _ := range LocationPostSewersMap {
+		psLocs = append(psLocs, loc)
+	}
+	sort.Ints(psLocs)
+
+	for _, loc := range psLocs {
+		if !isKnownLocation(loc) {
+			addProblem("LocationPostSewersMap", "unknown location: %d", loc)
+		}
+	}
+
+	//// IntraTransitions.
+
+	seen := map[defs.LocPair]struct{}{}
+	for _, lp := range IntraTransitions {
+		if _, ok := seen[lp]; ok {
+			addProblem("IntraTransitions", "duplicate entry: %s->%s",
+				LocationString(lp.From), LocationString(lp.To))
+		}
+		seen[lp] = struct{}{}
+
+		for _, loc := range []int{lp.From, lp.To} {
+			if _, ok := LocationDepthMap[loc]; !ok {
+				addProblem("IntraTransitions", "location has no depth: %s",
+					LocationFullString(loc))
+			}
+		}
+
+		rev := defs.LocPair{lp.To, lp.From}
+		if !TransitionIsIntra(rev) {
+			addProblem("IntraTransitions", "%s->%s has no reverse entry",
+				LocationString(lp.From), LocationString(lp.To))
+		}
+	}
+
+	//// LocationXListPairMap.
+
+	var lps []defs.LocPair
+	for lp, _ := range LocationXListPairMap {
+		lps = append(lps, lp)
+	}
+	sort.Slice(lps, func(i int, j int) bool {
+		if lps[i].From != lps[j].From {
+			return lps[i].From < lps[j].From
+		}
+		return lps[i].To < lps[j].To
+	})
+
+	for _, lp := range lps {
+		lpStr := fmt.Sprintf("%s->%s",
+			LocationString(lp.From), LocationString(lp.To))
+
+		for _, loc := range []int{lp.From, lp.To} {
+			if !isKnownLocation(loc) {
+				addProblem("LocationXListPairMap", "%s: unknown location: %d",
+					lpStr, loc)
+			}
+		}
+
+		zip, ok := locationSelectorBlock(lp.From)
+		if !ok {
+			addProblem("LocationXListPairMap", "%s: %s has no block",
+				lpStr, LocationFullString(lp.From))
+			continue
+		}
+
+		xp := LocationXListPairMap[lp]
+		for _, xl := range []TransXList{xp.Read, xp.Write} {
+			sels := append(append([]int(nil), xl.White...), xl.Black...)
+			for _, sel := range sels {
+				desc := SubLocDesc{
+					GameIdx:  zip.GameIdx,
+					BlockIdx: zip.BlockIdx,
+					Selector: sel,
+				}
+				if transitionAt(state, desc) == nil {
+					addProblem("LocationXListPairMap",
+						"%s: %s: no such transition",
+						lpStr, SubLocDescString(desc))
+				}
+			}
+		}
+	}
+
+	return problems
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/badvassal/wllib/decode"
 	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
 )
 
 // A TransOP is used to replace one transition with another.  It consists of four transitions:
@@ -21,11 +22,16 @@ type TransOp struct {
 	B defs.LocPair
 }
 
+// TransXList is a white list and black list of transition selectors.  If the
+// white list is non-empty, only the selectors it contains are kept.
 type TransXList struct {
 	Black []int
 	White []int
 }
 
+// TransXListPair contains the lists applied when reading a transition (i.e.,
+// when it is used as a copy source) and when writing one (i.e., when it gets
+// overwritten).
 type TransXListPair struct {
 	Read  TransXList
 	Write TransXList
@@ -43,6 +49,8 @@ func CopyTrans(dst *action.Transition, src action.Transition) {
 }
 
 // delistEntries applies white lists and black lists to a list of entries.
+// Both the location pair lists (LocationXListPairMap) and the per-selector
+// black lists (SelectorBlackListMap) are applied.
 func delistEntries(entries []*TransEntry, isRead bool) []*TransEntry {
 	if len(entries) == 0 {
 		return nil
@@ -93,6 +101,14 @@ func delistEntries(entries []*TransEntry, isRead bool) []*TransEntry {
 			}
 		}
 
+		if keep {
+			bl := SelectorBlackListMap[e.Desc()]
+			if isRead && bl.Read || !isRead && bl.Write {
+				log.Debugf("blacklisting %s (selector)", entryStr)
+				keep = false
+			}
+		}
+
 		if keep {
 			filtered = append(filtered, e)
 		}
@@ -114,8 +130,12 @@ type transOpCtxt struct {
 	BRev1WayUp []*TransEntry
 }
 
+// newTransOpCtxt resolves the routes that an op reads and writes.  An error
+// is returned if the op has no usable route; that is, if a route has no
+// round trip or is fully delisted.  state may be nil if only the routes are
+// needed.
 func newTransOpCtxt(coll *Collection, state *decode.DecodeState,
-	op TransOp) *transOpCtxt {
+	op TransOp) (*transOpCtxt, error) {
 
 	// We only filter the reverse routes in A and the forward routes in B;
 	// everything else is unfiltered.  Filtering is only necessary to restrict
@@ -130,58 +150,57 @@ func newTransOpCtxt(coll *Collection, state *decode.DecodeState,
 	if len(aFwd) == 0 || len(bFwd) == 0 ||
 		len(aRev) == 0 || len(bRev) == 0 {
 
-		log.Warnf("ignoring op %+v: no round trip", op)
-		return nil
+		return nil, wlerr.Errorf("no round trip")
 	}
 
-	isFullyDelisted := func(lp defs.LocPair, entries []*TransEntry) bool {
+	isFullyDelisted := func(lp defs.LocPair, entries []*TransEntry) error {
 		if len(entries) == 0 {
-			log.Warnf("delisted to 0: %s,%s",
+			return wlerr.Errorf("delisted to 0: %s,%s",
 				LocationString(lp.From), LocationString(lp.To))
-			return true
 		}
 
-		return false
+		return nil
 	}
 
-	aZIP := aFwd[0].FromBlock
-	aDB := state.Blocks[aZIP.GameIdx][aZIP.BlockIdx]
-
 	filtAFwd := delistEntries(aFwd, false)
-	if isFullyDelisted(op.A, filtAFwd) {
-		return nil
+	if err := isFullyDelisted(op.A, filtAFwd); err != nil {
+		return nil, err
 	}
 
 	filtARev := delistEntries(aRev, true)
-	if isFullyDelisted(op.A, filtARev) {
-		return nil
+	if err := isFullyDelisted(op.A, filtARev); err != nil {
+		return nil, err
 	}
 
-	bZIP := bRev[0].FromBlock
-	bDB := state.Blocks[bZIP.GameIdx][bZIP.BlockIdx]
-
 	filtBFwd := delistEntries(bFwd, true)
-	if isFullyDelisted(op.B, filtBFwd) {
-		return nil
+	if err := isFullyDelisted(op.B, filtBFwd); err != nil {
+		return nil, err
 	}
 
 	filtBRev := delistEntries(bRev, false)
-	if isFullyDelisted(op.B, filtBRev) {
-		return nil
+	if err := isFullyDelisted(op.B, filtBRev); err != nil {
+		return nil, err
 	}
 
-	filtBRev1WayUp := delistEntries(coll.Get1WayUp(op.B.To), false)
-	return &transOpCtxt{
+	toe := &transOpCtxt{
 		AFwd: filtAFwd,
 		ARev: filtARev,
-		ADB:  aDB,
 
 		BFwd: filtBFwd,
 		BRev: filtBRev,
-		BDB:  bDB,
 
-		BRev1WayUp: filtBRev1WayUp,
+		BRev1WayUp: delistEntries(coll.Get1WayUp(op.B.To), false),
+	}
+
+	if state != nil {
+		aZIP := aFwd[0].FromBlock
+		toe.ADB = state.Blocks[aZIP.GameIdx][aZIP.BlockIdx]
+
+		bZIP := bRev[0].FromBlock
+		toe.BDB = state.Blocks[bZIP.GameIdx][bZIP.BlockIdx]
 	}
+
+	return toe, nil
 }
 
 // ExecTransOp modifies a pair of transitions according to the specified
@@ -189,10 +208,25 @@ func newTransOpCtxt(coll *Collection, state *decode.DecodeState,
 //
 // 1. A.From --> A.to   BECOMES   A.From --> B.to
 // 2. A.From <-- A.to   BECOMES   A.From <-- B.to
-func ExecTransOp(coll *Collection, state *decode.DecodeState, op TransOp) {
-	toe := newTransOpCtxt(coll, state, op)
-	if toe == nil {
-		return
+//
+// The state should have been prepared with PrepareState before the collection
+// was built from it.  Fixups are not reapplied here.
+//
+// An error is returned if the op would overwrite a protected transition (see
+// ProtectedSelectorMap).  In this case, the state is left unmodified.
+func ExecTransOp(coll *Collection, state *decode.DecodeState, op TransOp) error {
+	toe, err := newTransOpCtxt(coll, state, op)
+	if err != nil {
+		log.Warnf("ignoring op %+v: %s", op, err.Error())
+		return nil
+	}
+
+	var writes []*TransEntry
+	writes = append(writes, toe.AFwd...)
+	writes = append(writes, toe.BRev...)
+	writes = append(writes, toe.BRev1WayUp...)
+	if err := checkProtected(writes); err != nil {
+		return wlerr.Wrapf(err, "failed to execute op %+v", op)
 	}
 
 	// For example:
@@ -250,4 +284,6 @@ func ExecTransOp(coll *Collection, state *decode.DecodeState, op TransOp) {
 		CopyTrans(toe.BDB.ActionTables.Transitions[e.Selector],
 			srcTrans)
 	}
+
+	return nil
 }
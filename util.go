@@ -4,12 +4,9 @@ import (
 	"fmt"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
 	"github.com/badvassal/wllib/defs"
-	"github.com/badvassal/wllib/gen"
-	"github.com/badvassal/wllib/gen/wlerr"
 )
 
 // LocationString produces a user-friendly string for the given location code.
@@ -64,59 +61,22 @@ func TransitionIsIntra(lp defs.LocPair) bool {
 	return false
 }
 
-// FixupTransitions converts some relative transitions to absolute.  The
-// transitions that it modifies are specified in a hardcoded list.
-func FixupTransitions(state *decode.DecodeState) error {
-	relToAbs := func(gameIdx int, blockIdx int, selector int,
-		coords gen.Point) error {
-
-		t := state.Blocks[gameIdx][blockIdx].ActionTables.Transitions[selector]
-		if !t.Relative {
-			return wlerr.Errorf("failed to convert transition to absolute: "+
-				"game=%d block=%d selector=%d: transition not relative",
-				gameIdx, blockIdx, selector)
-		}
-		oldT := *t
-
-		t.MakeAbsolute(coords)
-
-		log.Debugf("converted relative transition to absolute: "+
-			"game=%d block=%d selector=%d %+v --> %+v",
-			gameIdx, blockIdx, selector, oldT, t)
-
+// transitionAt retrieves the transition with the given block and selector.
+// It returns nil if no such transition exists.
+func transitionAt(state decode.DecodeState, desc SubLocDesc) *action.Transition {
+	if desc.GameIdx < 0 || desc.GameIdx >= len(state.Blocks) {
 		return nil
 	}
 
-	//// Make some relative transitions absolute.
-
-	// Needles --> Downtown East.
-	err := relToAbs(0, defs.Block0Needles, 11, gen.Point{30, 13})
-	if err != nil {
-		return err
-	}
-
-	// Needles --> Downtown West.
-	err = relToAbs(0, defs.Block0Needles, 20, gen.Point{1, 14})
-	if err != nil {
-		return err
+	blocks := state.Blocks[desc.GameIdx]
+	if desc.BlockIdx < 0 || desc.BlockIdx >= len(blocks) {
+		return nil
 	}
 
-	// Downtown West --> Needles.
-	err = relToAbs(0, defs.Block0NeedlesDowntownWest, 2, gen.Point{35, 29})
-	if err != nil {
-		return err
+	ts := blocks[desc.BlockIdx].ActionTables.Transitions
+	if desc.Selector < 0 || desc.Selector >= len(ts) {
+		return nil
 	}
 
-	//// Apply some miscellaneous fixups.
-
-	// The exit from the proton ax room uses a "previous" transition.  The
-	// previous location is incompatible with transition replacement, so change
-	// it to explicitly specify the coordinates outside the building in Las
-	// Vegas.
-	t := state.Blocks[1][defs.Block1FatFreddys].ActionTables.Transitions[5]
-	t.Location = defs.LocationLasVegas
-	t.LocX = 46
-	t.LocY = 12
-
-	return nil
+	return ts[desc.Selector]
 }
@@ -0,0 +1,184 @@
+package wlmanip
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// VanillaTransition is the expected vanilla value of a single transition.
+type VanillaTransition struct {
+	Relative bool
+	Location int
+	LocX     int
+	LocY     int
+}
+
+// originalFingerprints lists the fingerprints of the original release.  It is
+// populated from an unmodified copy of the game with CaptureDataVersion and
+// WriteDataVersionTables.
+var originalFingerprints = []string{}
+
+// originalVanillaMap contains the vanilla value of each transition referenced
+// by the original release's tables.  It is populated in the same manner as
+// originalFingerprints.
+var originalVanillaMap = map[SubLocDesc]VanillaTransition{}
+
+// vanillaOf extracts the fields of a transition that are compared against
+// vanilla values.
+func vanillaOf(t action.Transition) VanillaTransition {
+	return VanillaTransition{
+		Relative: t.Relative,
+		Location: t.Location,
+		LocX:     t.LocX,
+		LocY:     t.LocY,
+	}
+}
+
+// Matches indicates whether a transition has the expected vanilla value.
+func (v VanillaTransition) Matches(t action.Transition) bool {
+	return vanillaOf(t) == v
+}
+
+func (v VanillaTransition) String() string {
+	s := fmt.Sprintf("%s @%d,%d", LocationString(v.Location), v.LocX, v.LocY)
+	if v.Relative {
+		s += " (relative)"
+	}
+
+	return s
+}
+
+// ReferencedSelectors returns every transition referenced by a data
+// version's tables and fixups, sorted by game, block, and selector.
+func (v *DataVersion) ReferencedSelectors() []SubLocDesc {
+	set := map[SubLocDesc]struct{}{}
+
+	for desc, _ := range v.SubLocMap {
+		set[desc] = struct{}{}
+	}
+
+	for lp, xp := range v.LocationXListPairMap {
+		zip := defs.LocationBlockZIPMap[lp.From]
+		if zip == nil {
+			continue
+		}
+
+		for _, xl := range []TransXList{xp.Read, xp.Write} {
+			for _, sel := range append(append([]int{}, xl.White...),
+				xl.Black...) {
+
+				set[SubLocDesc{
+					GameIdx:  zip.GameIdx,
+					BlockIdx: zip.BlockIdx,
+					Selector: sel,
+				}] = struct{}{}
+			}
+		}
+	}
+
+	for _, name := range v.Fixups {
+		if f, err := LookupFixup(name); err == nil {
+			for _, desc := range f.Selectors {
+				set[desc] = struct{}{}
+			}
+		}
+	}
+
+	descs := make([]SubLocDesc, 0, len(set))
+	for desc, _ := range set {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	return descs
+}
+
+// CaptureDataVersion records the fingerprint of an unmodified game and the
+// vanilla value of every transition referenced by a data version.  The
+// fingerprint is appended to the version's list of fingerprints.  An error is
+// returned if any of the version's fixups has already been applied to the
+// state.
+func CaptureDataVersion(v *DataVersion, state decode.DecodeState) error {
+	for _, name := range v.Fixups {
+		f, err := LookupFixup(name)
+		if err != nil {
+			return err
+		}
+		if err := f.Check(state); err != nil {
+			return wlerr.Wrapf(err,
+				"failed to capture data version %s", v.Name)
+		}
+	}
+
+	vanilla := map[SubLocDesc]VanillaTransition{}
+	for _, desc := range v.ReferencedSelectors() {
+		t := transitionAt(state, desc)
+		if t == nil {
+			return wlerr.Errorf(
+				"failed to capture data version %s: no transition: %s",
+				v.Name, SubLocDescString(desc))
+		}
+		vanilla[desc] = vanillaOf(*t)
+	}
+
+	fp := v.Fingerprint(state)
+	for _, f := range v.Fingerprints {
+		if f == fp {
+			fp = ""
+			break
+		}
+	}
+	if fp != "" {
+		v.Fingerprints = append(v.Fingerprints, fp)
+	}
+	v.Vanilla = vanilla
+
+	return nil
+}
+
+// WriteDataVersionTables writes a data version's fingerprints and vanilla
+// values as Go source, suitable for embedding a captured version in this
+// package.
+func WriteDataVersionTables(w io.Writer, v *DataVersion) error {
+	descs := make([]SubLocDesc, 0, len(v.Vanilla))
+	for desc, _ := range v.Vanilla {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Fingerprints: []string{\n")
+	for _, fp := range v.Fingerprints {
+		printf("\t%q,\n", fp)
+	}
+	printf("}\n\nVanilla: map[SubLocDesc]VanillaTransition{\n")
+	for _, desc := range descs {
+		van := v.Vanilla[desc]
+		printf("\tSubLocDesc{%d, %d, %d}: VanillaTransition{%v, %d, %d, %d},\n",
+			desc.GameIdx, desc.BlockIdx, desc.Selector,
+			van.Relative, van.Location, van.LocX, van.LocY)
+	}
+	printf("}\n")
+
+	if err != nil {
+		return wlerr.Wrapf(err, "failed to write data version tables")
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package wlmanip
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// VerifyProblem describes a transition that differs from its expected
+// vanilla value.
+type VerifyProblem struct {
+	Desc   SubLocDesc
+	Source string // The table or fixup that references the transition.
+	Msg    string
+}
+
+// VerifyReport is the result of verifying a game against its expected
+// vanilla state.
+type VerifyReport struct {
+	Problems []VerifyProblem
+}
+
+// OK indicates whether the verified game is in its vanilla state.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (p VerifyProblem) String() string {
+	return fmt.Sprintf("%s: %s: %s", SubLocDescString(p.Desc), p.Source, p.Msg)
+}
+
+func (r *VerifyReport) String() string {
+	var lines []string
+	for _, p := range r.Problems {
+		lines = append(lines, p.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// exactLocPair determines the exact from/to locations of a transition in the
+// same manner as Collect.
+func exactLocPair(desc SubLocDesc, blockLoc int, toLoc int) defs.LocPair {
+	lp := selectorToSubLocs(desc)
+	if lp.From == -1 {
+		lp.From = blockLoc
+	}
+	if lp.To == -1 {
+		lp.To = toLoc
+	}
+
+	return lp
+}
+
+// VerifyVanilla checks every transition referenced by SubLocMap,
+// LocationXListPairMap, and the enabled fixups against its expected vanilla
+// value (see DataVersion.Vanilla).  A referenced transition without a known
+// vanilla value is reported as a problem, so a game is only considered
+// vanilla if every referenced transition could be verified.  Games that have
+// already been modified (including by FixupTransitions) or that are
+// corrupted produce a report containing one problem per offending selector.
+// An error is returned if the state does not have the shape of a Wasteland
+// game, or if the active data version has no vanilla reference values at all
+// (see CaptureDataVersion).
+func VerifyVanilla(state decode.DecodeState) (*VerifyReport, error) {
+	if len(state.Blocks) != 2 ||
+		len(state.Blocks[0]) != defs.Block0NumBlocks ||
+		len(state.Blocks[1]) != defs.Block1NumBlocks {
+
+		return nil, wlerr.Errorf("failed to verify game: invalid block count")
+	}
+
+	v := ActiveDataVersion()
+	if len(v.Vanilla) == 0 {
+		return nil, wlerr.Errorf(
+			"failed to verify game: no vanilla reference for data version %s",
+			v.Name)
+	}
+
+	r := &VerifyReport{}
+	addProblem := func(desc SubLocDesc, source string, format string,
+		args ...interface{}) {
+
+		r.Problems = append(r.Problems, VerifyProblem{
+			Desc:   desc,
+			Source: source,
+			Msg:    fmt.Sprintf(format, args...),
+		})
+	}
+
+	//// Vanilla values.
+
+	for _, desc := range v.ReferencedSelectors() {
+		t := transitionAt(state, desc)
+		if t == nil {
+			// Reported below along with the table that references it.
+			continue
+		}
+
+		want, ok := v.Vanilla[desc]
+		if !ok {
+			addProblem(desc, "data version "+v.Name,
+				"no vanilla reference value")
+			continue
+		}
+		if !want.Matches(*t) {
+			addProblem(desc, "data version "+v.Name, "have %s, want %s",
+				vanillaOf(*t).String(), want.String())
+		}
+	}
+
+	//// SubLocMap.
+
+	var descs []SubLocDesc
+	for desc, _ := range SubLocMap {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	// All entrances to a sub-location should lead to the same block, and all
+	// exits should leave from the same block.
+	entryLocs := map[int]int{}
+	exitBlocks := map[int]defs.BlockZIP{}
+	locs := NewLocationRegistry()
+
+	for _, desc := range descs {
+		t := transitionAt(state, desc)
+		if t == nil {
+			addProblem(desc, "SubLocMap", "no such transition")
+			continue
+		}
+
+		pair := SubLocMap[desc]
+		if locs.IsSubLocation(pair.To) {
+			if loc, ok := entryLocs[pair.To]; !ok {
+				entryLocs[pair.To] = t.Location
+			} else if t.Location != loc {
+				addProblem(desc, "SubLocMap",
+					"entrance to %s leads to %s; other entrances lead to %s",
+					LocationString(pair.To), LocationString(t.Location),
+					LocationString(loc))
+			}
+		}
+
+		if locs.IsSubLocation(pair.From) {
+			zip := defs.BlockZIP{
+				GameIdx:  desc.GameIdx,
+				BlockIdx: desc.BlockIdx,
+			}
+			if z, ok := exitBlocks[pair.From]; !ok {
+				exitBlocks[pair.From] = zip
+			} else if z != zip {
+				addProblem(desc, "SubLocMap",
+					"exit from %s in block %+v; other exits in block %+v",
+					LocationString(pair.From), zip, z)
+			}
+		}
+	}
+
+	//// LocationXListPairMap.
+
+	var lps []defs.LocPair
+	for lp, _ := range LocationXListPairMap {
+		lps = append(lps, lp)
+	}
+	sort.Slice(lps, func(i int, j int) bool {
+		if lps[i].From != lps[j].From {
+			return lps[i].From < lps[j].From
+		}
+		return lps[i].To < lps[j].To
+	})
+
+	for _, lp := range lps {
+		zip := defs.LocationBlockZIPMap[lp.From]
+		if zip == nil {
+			// Sub-locations don't have a block of their own.
+			continue
+		}
+
+		xp := LocationXListPairMap[lp]
+		var sels []int
+		for _, xl := range []TransXList{xp.Read, xp.Write} {
+			sels = append(sels, xl.White...)
+			sels = append(sels, xl.Black...)
+		}
+
+		for _, sel := range sels {
+			desc := SubLocDesc{
+				GameIdx:  zip.GameIdx,
+				BlockIdx: zip.BlockIdx,
+				Selector: sel,
+			}
+
+			t := transitionAt(state, desc)
+			if t == nil {
+				addProblem(desc, "LocationXListPairMap", "no such transition")
+				continue
+			}
+
+			have := exactLocPair(desc, lp.From, t.Location)
+			if have != lp {
+				addProblem(desc, "LocationXListPairMap",
+					"transition leads %s->%s; want %s->%s",
+					LocationString(have.From), LocationString(have.To),
+					LocationString(lp.From), LocationString(lp.To))
+			}
+		}
+	}
+
+	//// Fixups.
+
+	for _, f := range fixups {
+		if !f.Enabled {
+			continue
+		}
+
+		source := "fixup " + f.Name
+		if f.Applied(state) {
+			for _, desc := range f.Selectors {
+				addProblem(desc, source, "fixup already applied")
+			}
+		} else {
+			missing := false
+			for _, desc := range f.Selectors {
+				if transitionAt(state, desc) == nil {
+					addProblem(desc, source, "no such transition")
+					missing = true
+				}
+			}
+			if missing {
+				continue
+			}
+
+			if err := f.Check(state); err != nil {
+				var desc SubLocDesc
+				if len(f.Selectors) > 0 {
+					desc = f.Selectors[0]
+				}
+				addProblem(desc, source, "%s", err.Error())
+			}
+		}
+	}
+
+	return r, nil
+}
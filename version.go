@@ -0,0 +1,366 @@
+package wlmanip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/badvassal/wllib/decode"
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// DataVersion describes a release of the GAME1 and GAME2 files along with the
+// tables and fixups that apply to it.  Selector numbers are specific to a
+// release, so the tables cannot be shared among versions with different
+// layouts.
+type DataVersion struct {
+	Name string
+
+	// Fingerprints lists the known fingerprints (see Fingerprint) of this
+	// version.
+	Fingerprints []string
+
+	// MatchTables allows this version to be identified structurally as a
+	// fallback if no registered version has a matching fingerprint (see
+	// matchesTables).  A structural match is reported as such by
+	// DetectDataVersion.
+	MatchTables bool
+
+	SubLocMap            map[SubLocDesc]defs.LocPair
+	LocationXListPairMap map[defs.LocPair]TransXListPair
+
+	// Vanilla contains the expected vanilla value of each transition
+	// referenced by the version's tables and fixups (see CaptureDataVersion).
+	Vanilla map[SubLocDesc]VanillaTransition
+
+	// Fixups lists the names of the fixups that apply to this version.
+	Fixups []string
+}
+
+// OriginalDataVersion describes the original release of Wasteland.  It is
+// identified by fingerprint, or structurally if its fingerprint is unknown.
+var OriginalDataVersion = &DataVersion{
+	Name:                 "original",
+	Fingerprints:         originalFingerprints,
+	MatchTables:          true,
+	SubLocMap:            SubLocMap,
+	LocationXListPairMap: LocationXListPairMap,
+	Vanilla:              originalVanillaMap,
+	Fixups: []string{
+		"needles-downtown-east",
+		"needles-downtown-west",
+		"downtown-west-needles",
+		"proton-ax-room-exit",
+	},
+}
+
+// dataVersions is the registry of known data versions.
+var dataVersions = []*DataVersion{
+	OriginalDataVersion,
+}
+
+// activeDataVersion is the version whose tables are currently installed.
+var activeDataVersion = OriginalDataVersion
+
+// matchesTables indicates whether the given state is compatible with a data
+// version's tables and fixups.  This is a fallback for states whose
+// fingerprint is unknown:
+//   - every transition referenced by the version's tables must exist,
+//   - all entrances to a sub-location must lead to the same location,
+//   - every xlist selector must lead between the xlist's locations,
+//   - every fixup must find its transitions in either their vanilla or
+//     fixed-up state, and
+//   - every other transition with a known vanilla value must have that value.
+func (v *DataVersion) matchesTables(state decode.DecodeState) bool {
+	mismatch := func(desc SubLocDesc, format string, args ...interface{}) bool {
+		log.Debugf("data version %s mismatch: %s: %s", v.Name,
+			SubLocDescString(desc), fmt.Sprintf(format, args...))
+		return false
+	}
+
+	var descs []SubLocDesc
+	for desc, _ := range v.SubLocMap {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i int, j int) bool {
+		return subLocDescLess(descs[i], descs[j])
+	})
+
+	entryLocs := map[int]int{}
+	for _, desc := range descs {
+		t := transitionAt(state, desc)
+		if t == nil {
+			return mismatch(desc, "no transition")
+		}
+
+		pair := v.SubLocMap[desc]
+		if pair.From == -1 && pair.To >= SubLocationMin {
+			if loc, ok := entryLocs[pair.To]; !ok {
+				entryLocs[pair.To] = t.Location
+			} else if loc != t.Location {
+				return mismatch(desc, "entrance to %s leads to %s, not %s",
+					LocationString(pair.To), LocationString(t.Location),
+					LocationString(loc))
+			}
+		}
+	}
+
+	fixupDescs := v.fixupSelectors()
+	for _, name := range v.Fixups {
+		f, err := LookupFixup(name)
+		if err != nil {
+			return false
+		}
+		if !f.Applied(state) {
+			if err := f.Check(state); err != nil {
+				log.Debugf("data version %s mismatch: %s", v.Name, err.Error())
+				return false
+			}
+		}
+	}
+
+	// Every selector in an xlist leaving a regular location must exist in
+	// that location's block and lead to the xlist's destination.
+	for lp, xp := range v.LocationXListPairMap {
+		zip := defs.LocationBlockZIPMap[lp.From]
+		if zip == nil {
+			continue
+		}
+
+		for _, xl := range []TransXList{xp.Read, xp.Write} {
+			for _, sel := range append(append([]int{}, xl.White...),
+				xl.Black...) {
+
+				desc := SubLocDesc{
+					GameIdx:  zip.GameIdx,
+					BlockIdx: zip.BlockIdx,
+					Selector: sel,
+				}
+				t := transitionAt(state, desc)
+				if t == nil {
+					return mismatch(desc, "no transition")
+				}
+
+				to := t.Location
+				if pair, ok := v.SubLocMap[desc]; ok && pair.To != -1 {
+					to = pair.To
+				}
+				if to != lp.To {
+					return mismatch(desc, "leads to %s, not %s",
+						LocationString(to), LocationString(lp.To))
+				}
+			}
+		}
+	}
+
+	for desc, want := range v.Vanilla {
+		if _, ok := fixupDescs[desc]; ok {
+			continue
+		}
+
+		t := transitionAt(state, desc)
+		if t == nil {
+			return mismatch(desc, "no transition")
+		}
+		if !want.Matches(*t) {
+			return mismatch(desc, "have %s, want %s",
+				vanillaOf(*t).String(), want.String())
+		}
+	}
+
+	return true
+}
+
+// fixupSelectors returns the set of transitions modified by a data version's
+// own fixups.
+func (v *DataVersion) fixupSelectors() map[SubLocDesc]struct{} {
+	m := map[SubLocDesc]struct{}{}
+	for _, name := range v.Fixups {
+		if f, err := LookupFixup(name); err == nil {
+			for _, desc := range f.Selectors {
+				m[desc] = struct{}{}
+			}
+		}
+	}
+
+	return m
+}
+
+// Fingerprint computes a hash of the transition tables in the given state
+// from the point of view of a data version.  Transitions modified by the
+// version's own fixups are excluded so that a state has the same fingerprint
+// before and after FixupTransitions.  Other registered fixups (e.g., ones
+// added with RegisterFixup) do not affect the fingerprint.
+func (v *DataVersion) Fingerprint(state decode.DecodeState) string {
+	skip := v.fixupSelectors()
+
+	h := sha256.New()
+	for gameIdx, blocks := range state.Blocks {
+		for blockIdx, block := range blocks {
+			ts := block.ActionTables.Transitions
+			h.Write([]byte{byte(gameIdx), byte(blockIdx), byte(len(ts))})
+
+			for selector, t := range ts {
+				desc := SubLocDesc{
+					GameIdx:  gameIdx,
+					BlockIdx: blockIdx,
+					Selector: selector,
+				}
+				if _, ok := skip[desc]; ok {
+					h.Write([]byte{0xfe})
+				} else if t == nil {
+					h.Write([]byte{0xff})
+				} else {
+					h.Write(action.EncodeActionTransition(*t))
+				}
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RegisterDataVersion adds a data version to the registry.
+func RegisterDataVersion(v *DataVersion) error {
+	for _, cur := range dataVersions {
+		if cur.Name == v.Name {
+			return wlerr.Errorf(
+				"failed to register data version %s: duplicate name", v.Name)
+		}
+	}
+
+	for _, name := range v.Fixups {
+		if _, err := LookupFixup(name); err != nil {
+			return wlerr.Wrapf(err,
+				"failed to register data version %s", v.Name)
+		}
+	}
+
+	dataVersions = append(dataVersions, v)
+	return nil
+}
+
+// DataVersionMatch describes how a data version was identified.
+type DataVersionMatch struct {
+	Version     *DataVersion
+	Fingerprint string
+
+	// Structural indicates that the version was identified by its tables
+	// (see DataVersion.MatchTables) because no registered version has a
+	// matching fingerprint.
+	Structural bool
+}
+
+// DetectDataVersion identifies the data version of the given unmodified (or
+// freshly prepared) state.  A version with a matching fingerprint is
+// preferred; otherwise, versions that allow it are tried structurally in
+// registration order, and a warning is logged.  An error is returned if the
+// version is unknown.
+func DetectDataVersion(state decode.DecodeState) (DataVersionMatch, error) {
+	fps := make([]string, len(dataVersions))
+	for i, v := range dataVersions {
+		fps[i] = v.Fingerprint(state)
+		for _, f := range v.Fingerprints {
+			if f == fps[i] {
+				return DataVersionMatch{
+					Version:     v,
+					Fingerprint: fps[i],
+				}, nil
+			}
+		}
+	}
+
+	for i, v := range dataVersions {
+		if v.MatchTables && v.matchesTables(state) {
+			log.Warnf("data version %s identified structurally; "+
+				"fingerprint %s is unknown", v.Name, fps[i])
+			return DataVersionMatch{
+				Version:     v,
+				Fingerprint: fps[i],
+				Structural:  true,
+			}, nil
+		}
+	}
+
+	var strs []string
+	for i, v := range dataVersions {
+		strs = append(strs, v.Name+"="+fps[i])
+	}
+
+	return DataVersionMatch{}, wlerr.Errorf(
+		"unknown data version: fingerprints: %s", strings.Join(strs, ", "))
+}
+
+// UseDataVersion installs the tables of the given data version.  When
+// switching versions, the fixups that only apply to the previous version are
+// disabled and those that only apply to the new version are enabled.  All
+// other fixups (including user-registered ones and those shared by both
+// versions) keep their current setting.
+func UseDataVersion(v *DataVersion) {
+	SubLocMap = v.SubLocMap
+	LocationXListPairMap = v.LocationXListPairMap
+
+	if prev := activeDataVersion; prev != v {
+		names := func(dv *DataVersion) map[string]struct{} {
+			m := map[string]struct{}{}
+			for _, name := range dv.Fixups {
+				m[name] = struct{}{}
+			}
+			return m
+		}
+		prevNames := names(prev)
+		newNames := names(v)
+
+		for _, f := range fixups {
+			_, inPrev := prevNames[f.Name]
+			_, inNew := newNames[f.Name]
+			if inPrev && !inNew {
+				f.Enabled = false
+			} else if inNew && !inPrev {
+				f.Enabled = true
+			}
+		}
+	}
+
+	activeDataVersion = v
+	log.Debugf("using data version: %s", v.Name)
+}
+
+// ActiveDataVersion returns the data version whose tables are currently
+// installed.
+func ActiveDataVersion() *DataVersion {
+	return activeDataVersion
+}
+
+// DataVersionNames returns the names of all registered data versions in
+// ascending order.
+func DataVersionNames() []string {
+	var names []string
+	for _, v := range dataVersions {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// selectDataVersion detects the data version of the given state and installs
+// its tables if they are not already installed.
+func selectDataVersion(state decode.DecodeState) error {
+	m, err := DetectDataVersion(state)
+	if err != nil {
+		return err
+	}
+
+	if m.Version != activeDataVersion {
+		UseDataVersion(m.Version)
+	}
+
+	return nil
+}
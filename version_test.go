@@ -0,0 +1,98 @@
+package wlmanip
+
+import (
+	"testing"
+
+	"github.com/badvassal/wllib/decode/action"
+	"github.com/badvassal/wllib/defs"
+)
+
+// registerTestFixup registers a fixup that modifies a transition outside of
+// any data version.  The returned function unregisters it.
+func registerTestFixup(t *testing.T) (*Fixup, func()) {
+	f := NewTransFixup("test-fixup", "Test fixup", TransPatch{
+		Desc: SubLocDesc{1, defs.Block1LasVegas, 3},
+		IsVanilla: func(t action.Transition) bool {
+			return t.LocX == 5
+		},
+		IsPatched: func(t action.Transition) bool {
+			return t.LocX == 6
+		},
+		Patch: func(t *action.Transition) {
+			t.LocX = 6
+		},
+	})
+
+	n := len(fixups)
+	if err := RegisterFixup(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return f, func() { fixups = fixups[:n] }
+}
+
+func TestFingerprintIgnoresOtherFixups(t *testing.T) {
+	state := testState()
+	want := OriginalDataVersion.Fingerprint(state)
+
+	_, unregister := registerTestFixup(t)
+	defer unregister()
+
+	if have := OriginalDataVersion.Fingerprint(state); have != want {
+		t.Errorf("fingerprint changed by registering a fixup: have=%s want=%s",
+			have, want)
+	}
+
+	// The version's own fixups must not affect the fingerprint either.
+	if err := FixupTransitions(&state); err != nil {
+		t.Fatalf("%v", err)
+	}
+	state.Blocks[1][defs.Block1LasVegas].ActionTables.Transitions[3].LocX = 5
+	if have := OriginalDataVersion.Fingerprint(state); have != want {
+		t.Errorf("fingerprint changed by fixups: have=%s want=%s", have, want)
+	}
+}
+
+func TestUseDataVersionKeepsOtherFixups(t *testing.T) {
+	f, unregister := registerTestFixup(t)
+	defer unregister()
+
+	other := &DataVersion{
+		Name:                 "test-version",
+		SubLocMap:            OriginalDataVersion.SubLocMap,
+		LocationXListPairMap: OriginalDataVersion.LocationXListPairMap,
+		Fixups:               []string{"needles-downtown-east"},
+	}
+
+	west, err := LookupFixup("needles-downtown-west")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	east, err := LookupFixup("needles-downtown-east")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	UseDataVersion(OriginalDataVersion)
+	defer UseDataVersion(OriginalDataVersion)
+
+	east.Enabled = false
+	f.Enabled = true
+
+	UseDataVersion(other)
+	if !f.Enabled {
+		t.Errorf("user fixup disabled by version switch")
+	}
+	if east.Enabled {
+		t.Errorf("explicitly disabled shared fixup re-enabled")
+	}
+	if west.Enabled {
+		t.Errorf("fixup of previous version still enabled")
+	}
+
+	UseDataVersion(OriginalDataVersion)
+	if !west.Enabled {
+		t.Errorf("fixup of new version not enabled")
+	}
+	east.Enabled = true
+}
@@ -0,0 +1,138 @@
+package wlmanip
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/badvassal/wllib/defs"
+	"github.com/badvassal/wllib/gen/wlerr"
+)
+
+// SelectorBlackList black lists a single transition selector.  Read prevents
+// the transition from being copied elsewhere; Write prevents it from being
+// overwritten.
+type SelectorBlackList struct {
+	Read  bool
+	Write bool
+}
+
+// SelectorBlackListMap contains black listed transitions keyed by their exact
+// block and selector.  Unlike LocationXListPairMap, these entries apply
+// regardless of where a transition currently leads.
+var SelectorBlackListMap = map[SubLocDesc]SelectorBlackList{}
+
+// ParseSelectors converts a selector list string to a sorted set of
+// selectors.  The string is a comma separated list of selectors and inclusive
+// ranges; e.g., "1,4-6,12" yields [1 4 5 6 12].
+func ParseSelectors(s string) ([]int, error) {
+	parseSel := func(str string) (int, error) {
+		sel, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			return 0, wlerr.Errorf("invalid selector: \"%s\"", str)
+		}
+		if sel < 0 {
+			return 0, wlerr.Errorf("invalid selector: have=%d want>=0", sel)
+		}
+
+		return sel, nil
+	}
+
+	seen := map[int]struct{}{}
+
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := parseSel(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		last := first
+		if len(bounds) > 1 {
+			last, err = parseSel(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, wlerr.Errorf(
+					"invalid selector range: \"%s\": end precedes start", part)
+			}
+		}
+
+		for sel := first; sel <= last; sel++ {
+			seen[sel] = struct{}{}
+		}
+	}
+
+	sels := make([]int, 0, len(seen))
+	for sel, _ := range seen {
+		sels = append(sels, sel)
+	}
+	sort.Ints(sels)
+
+	return sels, nil
+}
+
+// mergeSelectors combines two selector lists, discarding duplicates.
+func mergeSelectors(a []int, b []int) []int {
+	seen := map[int]struct{}{}
+
+	var merged []int
+	for _, sel := range append(append([]int{}, a...), b...) {
+		if _, ok := seen[sel]; !ok {
+			seen[sel] = struct{}{}
+			merged = append(merged, sel)
+		}
+	}
+
+	return merged
+}
+
+// AddLocPairXList merges the given white lists and black lists into the
+// entry for the specified location pair in LocationXListPairMap.  The
+// location pair consists of exact locations.
+func AddLocPairXList(lp defs.LocPair, xp TransXListPair) {
+	cur := LocationXListPairMap[lp]
+
+	cur.Read.Black = mergeSelectors(cur.Read.Black, xp.Read.Black)
+	cur.Read.White = mergeSelectors(cur.Read.White, xp.Read.White)
+	cur.Write.Black = mergeSelectors(cur.Write.Black, xp.Write.Black)
+	cur.Write.White = mergeSelectors(cur.Write.White, xp.Write.White)
+
+	LocationXListPairMap[lp] = cur
+}
+
+// AddSelectorBlackList black lists a set of selectors in a single block.
+// selectors is parsed with ParseSelectors.  For example, the following
+// prevents selectors 5 through 7 in Spade's Casino from ever being
+// overwritten:
+//
+//	AddSelectorBlackList(defs.BlockZIP{1, defs.Block1SpadesCasino}, "5-7",
+//	    SelectorBlackList{Write: true})
+func AddSelectorBlackList(zip defs.BlockZIP, selectors string,
+	bl SelectorBlackList) error {
+
+	sels, err := ParseSelectors(selectors)
+	if err != nil {
+		return err
+	}
+
+	for _, sel := range sels {
+		desc := SubLocDesc{
+			GameIdx:  zip.GameIdx,
+			BlockIdx: zip.BlockIdx,
+			Selector: sel,
+		}
+
+		cur := SelectorBlackListMap[desc]
+		cur.Read = cur.Read || bl.Read
+		cur.Write = cur.Write || bl.Write
+		SelectorBlackListMap[desc] = cur
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package wlmanip
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/badvassal/wllib/defs"
+)
+
+func TestParseSelectors(t *testing.T) {
+	sels, err := ParseSelectors("12, 4-6,1,5")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []int{1, 4, 5, 6, 12}; !reflect.DeepEqual(sels, want) {
+		t.Errorf("have=%v want=%v", sels, want)
+	}
+
+	for _, s := range []string{"6-4", "x", "-1", "3-"} {
+		if sels, err := ParseSelectors(s); err == nil {
+			t.Errorf("parsed invalid selector list \"%s\": %v", s, sels)
+		}
+	}
+}
+
+// xlistTestEntries creates a route of three selectors from Downtown East to
+// Needles.  The pair has no built-in rules.
+func xlistTestEntries() []*TransEntry {
+	var entries []*TransEntry
+	for _, sel := range []int{11, 12, 13} {
+		entries = append(entries, &TransEntry{
+			FromBlock:    defs.BlockZIP{0, defs.Block0NeedlesDowntownEast},
+			FromLoc:      defs.LocationNeedlesDowntownEast,
+			Selector:     sel,
+			FromExactLoc: defs.LocationNeedlesDowntownEast,
+			ToExactLoc:   defs.LocationNeedles,
+		})
+	}
+
+	return entries
+}
+
+func entrySelectors(entries []*TransEntry) []int {
+	var sels []int
+	for _, e := range entries {
+		sels = append(sels, e.Selector)
+	}
+
+	return sels
+}
+
+func TestAddLocPairXListWrite(t *testing.T) {
+	lp := defs.LocPair{defs.LocationNeedlesDowntownEast, defs.LocationNeedles}
+
+	orig, hadOrig := LocationXListPairMap[lp]
+	defer func() {
+		if hadOrig {
+			LocationXListPairMap[lp] = orig
+		} else {
+			delete(LocationXListPairMap, lp)
+		}
+	}()
+
+	AddLocPairXList(lp, TransXListPair{Write: TransXList{Black: []int{12}}})
+	AddLocPairXList(lp, TransXListPair{Write: TransXList{Black: []int{12, 13}}})
+
+	entries := xlistTestEntries()
+
+	// Write rules must not affect reads.
+	if have := entrySelectors(delistEntries(entries, true)); !reflect.DeepEqual(
+		have, []int{11, 12, 13}) {
+
+		t.Errorf("read: have=%v want=[11 12 13]", have)
+	}
+	if have := entrySelectors(delistEntries(entries, false)); !reflect.DeepEqual(
+		have, []int{11}) {
+
+		t.Errorf("write: have=%v want=[11]", have)
+	}
+}
+
+func TestAddSelectorBlackList(t *testing.T) {
+	zip := defs.BlockZIP{0, defs.Block0NeedlesDowntownEast}
+	if err := AddSelectorBlackList(zip, "12-13",
+		SelectorBlackList{Write: true}); err != nil {
+
+		t.Fatalf("%v", err)
+	}
+	defer func() {
+		for _, sel := range []int{12, 13} {
+			delete(SelectorBlackListMap,
+				SubLocDesc{0, defs.Block0NeedlesDowntownEast, sel})
+		}
+	}()
+
+	entries := xlistTestEntries()
+
+	if have := entrySelectors(delistEntries(entries, true)); !reflect.DeepEqual(
+		have, []int{11, 12, 13}) {
+
+		t.Errorf("read: have=%v want=[11 12 13]", have)
+	}
+	if have := entrySelectors(delistEntries(entries, false)); !reflect.DeepEqual(
+		have, []int{11}) {
+
+		t.Errorf("write: have=%v want=[11]", have)
+	}
+
+	if err := AddSelectorBlackList(zip, "5-3",
+		SelectorBlackList{Write: true}); err == nil {
+
+		t.Errorf("accepted an invalid selector range")
+	}
+}